@@ -0,0 +1,357 @@
+package datasource
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// ActivityStorePageSize is a number of activities requested with a single list request, max allowed by Strava
+const ActivityStorePageSize = 200
+
+// ActivityStoreBackfillPages limits number of pages of older activities loaded by a single sync
+const ActivityStoreBackfillPages = 5
+
+var (
+	// activities contains summaries keyed by start time and id, so time range is read with a single scan
+	activitiesBucket = []byte("activities")
+	// activityKeys maps activity id to the key in activities bucket
+	activityKeysBucket = []byte("activity_keys")
+	// details contains detailed activities keyed by id
+	detailsBucket = []byte("details")
+	metaBucket    = []byte("meta")
+	stateKey      = []byte("state")
+	// Numbers of stored activities and details are updated along with the buckets, so they're read without
+	// walking the buckets
+	activitiesCountKey = []byte("activities_count")
+	detailsCountKey    = []byte("details_count")
+)
+
+// ActivityStoreState is a progress of the activity store sync. Times are unix seconds.
+type ActivityStoreState struct {
+	// AthleteId is a Strava id of the athlete whose activities are stored
+	AthleteId int64 `json:"athleteId,omitempty"`
+	// NewestActivityTime and OldestActivityTime are start times of the newest and oldest stored activities
+	NewestActivityTime int64 `json:"newestActivityTime"`
+	OldestActivityTime int64 `json:"oldestActivityTime"`
+	// HistoryComplete is set when all athlete activities are stored
+	HistoryComplete bool  `json:"historyComplete"`
+	LastSyncAt      int64 `json:"lastSyncAt"`
+	Activities      int   `json:"activities"`
+	Details         int   `json:"details"`
+}
+
+// covers returns true if all activities started after the given time are stored
+func (s *ActivityStoreState) covers(after int64) bool {
+	return s.HistoryComplete || (s.OldestActivityTime > 0 && after >= s.OldestActivityTime)
+}
+
+// ActivityStore mirrors activities of the default athlete in the bbolt database in the data dir, so
+// Activities queries are answered without calling Strava API.
+type ActivityStore struct {
+	path string
+	db   *bolt.DB
+	refs int
+}
+
+var (
+	activityStoresMu sync.Mutex
+	activityStores   = make(map[string]*ActivityStore)
+)
+
+// openActivityStore returns store for the database file. Database can be opened only once, so store is shared
+// by the data source instances until all of them release it.
+func openActivityStore(path string) (*ActivityStore, error) {
+	activityStoresMu.Lock()
+	defer activityStoresMu.Unlock()
+
+	if store, ok := activityStores[path]; ok {
+		store.refs++
+		return store, nil
+	}
+
+	db, err := bolt.Open(path, 0640, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("cannot open activity store: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{activitiesBucket, activityKeysBucket, detailsBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		// Store created before counts were kept in meta is counted once
+		meta := tx.Bucket(metaBucket)
+		if meta.Get(activitiesCountKey) == nil {
+			if err := setCount(tx, activitiesCountKey, tx.Bucket(activityKeysBucket).Stats().KeyN); err != nil {
+				return err
+			}
+		}
+		if meta.Get(detailsCountKey) == nil {
+			if err := setCount(tx, detailsCountKey, tx.Bucket(detailsBucket).Stats().KeyN); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot initialize activity store: %w", err)
+	}
+
+	store := &ActivityStore{path: path, db: db, refs: 1}
+	activityStores[path] = store
+	return store, nil
+}
+
+// Release closes database when it's not used by any data source instance
+func (s *ActivityStore) Release() {
+	activityStoresMu.Lock()
+	defer activityStoresMu.Unlock()
+
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	delete(activityStores, s.path)
+	if err := s.db.Close(); err != nil {
+		log.DefaultLogger.Warn("Error closing activity store", "path", s.path, "error", err)
+	}
+}
+
+// State returns sync progress along with number of stored activities
+func (s *ActivityStore) State() (ActivityStoreState, error) {
+	state := ActivityStoreState{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(metaBucket).Get(stateKey); data != nil {
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+		}
+		state.Activities = getCount(tx, activitiesCountKey)
+		state.Details = getCount(tx, detailsCountKey)
+		return nil
+	})
+	return state, err
+}
+
+// SetState saves sync progress
+func (s *ActivityStore) SetState(state ActivityStoreState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(stateKey, data)
+	})
+}
+
+// PutActivities saves activity summaries replacing existing ones
+func (s *ActivityStore) PutActivities(activities []json.RawMessage) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, data := range activities {
+			activity := StravaActivity{}
+			if err := json.Unmarshal(data, &activity); err != nil {
+				return fmt.Errorf("error parsing activity: %w", err)
+			}
+			if err := putActivity(tx, &activity, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func putActivity(tx *bolt.Tx, activity *StravaActivity, data json.RawMessage) error {
+	id := idKey(activity.Id)
+	key := activityKey(activity.StartDate, activity.Id)
+	keys := tx.Bucket(activityKeysBucket)
+	activities := tx.Bucket(activitiesBucket)
+
+	// Start time of the edited activity might be changed, so previous entry is removed
+	if oldKey := keys.Get(id); oldKey != nil {
+		if err := activities.Delete(oldKey); err != nil {
+			return err
+		}
+	} else if err := addCount(tx, activitiesCountKey, 1); err != nil {
+		return err
+	}
+	if err := activities.Put(key, data); err != nil {
+		return err
+	}
+	return keys.Put(id, key)
+}
+
+// PutActivityDetails saves detailed activity, summary of the activity is updated as well
+func (s *ActivityStore) PutActivityDetails(data json.RawMessage) error {
+	activity := StravaActivity{}
+	if err := json.Unmarshal(data, &activity); err != nil {
+		return fmt.Errorf("error parsing activity: %w", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := putActivity(tx, &activity, data); err != nil {
+			return err
+		}
+		details := tx.Bucket(detailsBucket)
+		if details.Get(idKey(activity.Id)) == nil {
+			if err := addCount(tx, detailsCountKey, 1); err != nil {
+				return err
+			}
+		}
+		return details.Put(idKey(activity.Id), data)
+	})
+}
+
+// DeleteActivity removes activity summary and details
+func (s *ActivityStore) DeleteActivity(activityId int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		id := idKey(activityId)
+		keys := tx.Bucket(activityKeysBucket)
+		if key := keys.Get(id); key != nil {
+			if err := tx.Bucket(activitiesBucket).Delete(key); err != nil {
+				return err
+			}
+			if err := keys.Delete(id); err != nil {
+				return err
+			}
+			if err := addCount(tx, activitiesCountKey, -1); err != nil {
+				return err
+			}
+		}
+		return deleteDetails(tx, id)
+	})
+}
+
+// DeleteDetails removes stored details of the activity, so they're loaded again
+func (s *ActivityStore) DeleteDetails(activityId int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return deleteDetails(tx, idKey(activityId))
+	})
+}
+
+func deleteDetails(tx *bolt.Tx, id []byte) error {
+	details := tx.Bucket(detailsBucket)
+	if details.Get(id) == nil {
+		return nil
+	}
+	if err := details.Delete(id); err != nil {
+		return err
+	}
+	return addCount(tx, detailsCountKey, -1)
+}
+
+// Clear removes all activities and resets sync progress
+func (s *ActivityStore) Clear() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{activitiesBucket, activityKeysBucket, detailsBucket, metaBucket} {
+			if err := tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Activities returns activities started between after and before timestamps ordered by start time. It returns
+// false if stored history doesn't cover the time range yet.
+func (s *ActivityStore) Activities(after int64, before int64) ([]StravaActivity, bool, error) {
+	state, err := s.State()
+	if err != nil || !state.covers(after) {
+		return nil, false, err
+	}
+
+	activities := make([]StravaActivity, 0)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(activitiesBucket).Cursor()
+		for k, v := c.Seek(activityKey(time.Unix(after+1, 0), 0)); k != nil; k, v = c.Next() {
+			if int64(binary.BigEndian.Uint64(k[:8])) >= before {
+				break
+			}
+			activity := StravaActivity{}
+			if err := json.Unmarshal(v, &activity); err != nil {
+				return fmt.Errorf("error parsing activity: %w", err)
+			}
+			activities = append(activities, activity)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return activities, true, nil
+}
+
+// ActivityDetails returns stored detailed activity
+func (s *ActivityStore) ActivityDetails(activityId int64) (*StravaActivity, bool) {
+	var activity *StravaActivity
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(detailsBucket).Get(idKey(activityId))
+		if data == nil {
+			return nil
+		}
+		activity = &StravaActivity{}
+		return json.Unmarshal(data, activity)
+	})
+	if err != nil || activity == nil {
+		return nil, false
+	}
+	return activity, true
+}
+
+// MissingDetails returns ids of up to limit latest activities which are stored without details
+func (s *ActivityStore) MissingDetails(limit int) ([]int64, error) {
+	ids := make([]int64, 0, limit)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		details := tx.Bucket(detailsBucket)
+		c := tx.Bucket(activitiesBucket).Cursor()
+		for k, _ := c.Last(); k != nil && len(ids) < limit; k, _ = c.Prev() {
+			if details.Get(k[8:]) == nil {
+				ids = append(ids, int64(binary.BigEndian.Uint64(k[8:])))
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// getCount returns number of stored items kept in meta bucket
+func getCount(tx *bolt.Tx, key []byte) int {
+	data := tx.Bucket(metaBucket).Get(key)
+	if len(data) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+func setCount(tx *bolt.Tx, key []byte, count int) error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(max(count, 0)))
+	return tx.Bucket(metaBucket).Put(key, data)
+}
+
+func addCount(tx *bolt.Tx, key []byte, delta int) error {
+	return setCount(tx, key, getCount(tx, key)+delta)
+}
+
+// activityKey orders activities by start time, id makes key unique
+func activityKey(startDate time.Time, activityId int64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(max(startDate.Unix(), 0)))
+	binary.BigEndian.PutUint64(key[8:], uint64(activityId))
+	return key
+}
+
+func idKey(activityId int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(activityId))
+	return key
+}
@@ -0,0 +1,194 @@
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestActivityStore(t *testing.T) *ActivityStore {
+	t.Helper()
+	store, err := openActivityStore(filepath.Join(t.TempDir(), "activities.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Release)
+	return store
+}
+
+func activityJSON(id int64, startTime int64) json.RawMessage {
+	startDate := time.Unix(startTime, 0).UTC().Format(time.RFC3339)
+	return json.RawMessage(fmt.Sprintf(`{"id": %d, "start_date": %q}`, id, startDate))
+}
+
+func TestActivityStoreStateCovers(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  ActivityStoreState
+		after  int64
+		covers bool
+	}{
+		{name: "empty store", state: ActivityStoreState{}, after: 1000, covers: false},
+		{name: "complete history", state: ActivityStoreState{HistoryComplete: true}, after: 0, covers: true},
+		{name: "range within history", state: ActivityStoreState{OldestActivityTime: 1000}, after: 2000, covers: true},
+		{name: "range starts at oldest activity", state: ActivityStoreState{OldestActivityTime: 1000}, after: 1000, covers: true},
+		{name: "range before history", state: ActivityStoreState{OldestActivityTime: 1000}, after: 500, covers: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if covers := tt.state.covers(tt.after); covers != tt.covers {
+				t.Errorf("expected %v, got %v", tt.covers, covers)
+			}
+		})
+	}
+}
+
+func TestActivityStoreActivities(t *testing.T) {
+	store := newTestActivityStore(t)
+	err := store.PutActivities([]json.RawMessage{
+		activityJSON(3, 3000),
+		activityJSON(1, 1000),
+		activityJSON(2, 2000),
+		activityJSON(4, 2000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetState(ActivityStoreState{OldestActivityTime: 1000}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		after    int64
+		before   int64
+		ok       bool
+		expected []int64
+	}{
+		{name: "all activities", after: 1000, before: 4000, ok: true, expected: []int64{2, 4, 3}},
+		{name: "after is exclusive", after: 2000, before: 4000, ok: true, expected: []int64{3}},
+		{name: "before is exclusive", after: 1000, before: 3000, ok: true, expected: []int64{2, 4}},
+		{name: "empty range", after: 3000, before: 4000, ok: true, expected: []int64{}},
+		{name: "range not covered", after: 500, before: 4000, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activities, ok, err := store.Activities(tt.after, tt.before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("expected covered %v, got %v", tt.ok, ok)
+			}
+			if ids := activityIds(activities); ok && !slices.Equal(ids, tt.expected) {
+				t.Errorf("expected activities %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestActivityStoreCounts(t *testing.T) {
+	store := newTestActivityStore(t)
+	expectCounts := func(activities int, details int) {
+		t.Helper()
+		state, err := store.State()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Activities != activities || state.Details != details {
+			t.Errorf("expected %d activities and %d details, got %d and %d", activities, details, state.Activities, state.Details)
+		}
+	}
+
+	if err := store.PutActivities([]json.RawMessage{activityJSON(1, 1000), activityJSON(2, 2000)}); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(2, 0)
+
+	// Edited activity with changed start time replaces the previous entry
+	if err := store.PutActivityDetails(activityJSON(1, 1500)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutActivityDetails(activityJSON(1, 1500)); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(2, 1)
+	if err := store.SetState(ActivityStoreState{HistoryComplete: true}); err != nil {
+		t.Fatal(err)
+	}
+	activities, _, err := store.Activities(0, 4000)
+	if err != nil || !slices.Equal(activityIds(activities), []int64{1, 2}) {
+		t.Errorf("expected activities [1 2], got %v (%v)", activityIds(activities), err)
+	}
+	if _, ok := store.ActivityDetails(1); !ok {
+		t.Error("expected details of activity 1")
+	}
+
+	if err := store.DeleteDetails(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteDetails(1); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(2, 0)
+	missing, err := store.MissingDetails(10)
+	if err != nil || !slices.Equal(missing, []int64{2, 1}) {
+		t.Errorf("expected missing details [2 1], got %v (%v)", missing, err)
+	}
+
+	if err := store.DeleteActivity(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteActivity(3); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(1, 0)
+
+	if err := store.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(0, 0)
+}
+
+func TestActivityStoreCountsMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "activities.db")
+	store, err := openActivityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutActivityDetails(activityJSON(1, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	// Remove counts, like in the store created by previous version
+	err = store.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(metaBucket)
+		if err := meta.Delete(activitiesCountKey); err != nil {
+			return err
+		}
+		return meta.Delete(detailsCountKey)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Release()
+
+	store, err = openActivityStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Release()
+	state, err := store.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Activities != 1 || state.Details != 1 {
+		t.Errorf("expected counted activities and details, got %+v", state)
+	}
+}
@@ -0,0 +1,46 @@
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// requestCacheKey returns canonical cache key of the API request. Key depends only on the data source, endpoint,
+// params and the identity of the caller (athlete and forwarded OAuth token), but not on the request formatting:
+// leading and trailing slashes of the endpoint are trimmed, params are unquoted and sorted. So the same request
+// made by the frontend, queries or prefetcher shares the cache entry.
+func (ds *StravaDatasourceInstance) requestCacheKey(query *StravaAPIRequest) string {
+	params := url.Values{}
+	for param, value := range query.Params {
+		params.Add(param, paramValue(value))
+	}
+	// Encode sorts params by key
+	request := fmt.Sprintf("%d:%s?%s", ds.dsInfo.ID, normalizeEndpoint(query.Endpoint), params.Encode())
+	return passThroughCacheKey(athleteCacheKey(HashString(request), query.AthleteId), query.AccessToken)
+}
+
+// passThroughCacheKey scopes request key to the forwarded OAuth token, so responses requested by one user with
+// OAuth pass-through enabled are never served to the others and requests aren't shared between them
+func passThroughCacheKey(requestHash string, accessToken string) string {
+	if accessToken == "" {
+		return requestHash
+	}
+	return fmt.Sprintf("%s:%s", requestHash, HashString(accessToken))
+}
+
+// normalizeEndpoint trims slashes, so "/athlete/activities" and "athlete/activities" are the same endpoint
+func normalizeEndpoint(endpoint string) string {
+	return strings.Trim(endpoint, "/")
+}
+
+// paramValue returns request param as it's sent to Strava API, with JSON strings unquoted
+func paramValue(value json.RawMessage) string {
+	valueUnquoted, err := strconv.Unquote(string(value))
+	if err != nil {
+		return string(value)
+	}
+	return valueUnquoted
+}
@@ -0,0 +1,128 @@
+package datasource
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestRequestCacheKey(t *testing.T) {
+	ds := &StravaDatasourceInstance{dsInfo: &backend.DataSourceInstanceSettings{ID: 1}}
+	otherDs := &StravaDatasourceInstance{dsInfo: &backend.DataSourceInstanceSettings{ID: 2}}
+
+	base := &StravaAPIRequest{
+		Endpoint: "athlete/activities",
+		Params:   map[string]json.RawMessage{"per_page": json.RawMessage(`200`), "page": json.RawMessage(`1`)},
+	}
+	baseKey := ds.requestCacheKey(base)
+
+	tests := []struct {
+		name  string
+		ds    *StravaDatasourceInstance
+		query *StravaAPIRequest
+		same  bool
+	}{
+		{
+			name: "slashes trimmed",
+			ds:   ds,
+			query: &StravaAPIRequest{
+				Endpoint: "/athlete/activities/",
+				Params:   map[string]json.RawMessage{"per_page": json.RawMessage(`200`), "page": json.RawMessage(`1`)},
+			},
+			same: true,
+		},
+		{
+			name: "params unquoted",
+			ds:   ds,
+			query: &StravaAPIRequest{
+				Endpoint: "athlete/activities",
+				Params:   map[string]json.RawMessage{"page": json.RawMessage(`"1"`), "per_page": json.RawMessage(`"200"`)},
+			},
+			same: true,
+		},
+		{
+			name: "forwarded access token",
+			ds:   ds,
+			query: &StravaAPIRequest{
+				Endpoint:    "athlete/activities",
+				Params:      map[string]json.RawMessage{"per_page": json.RawMessage(`200`), "page": json.RawMessage(`1`)},
+				AccessToken: "token",
+			},
+		},
+		{
+			name: "other param value",
+			ds:   ds,
+			query: &StravaAPIRequest{
+				Endpoint: "athlete/activities",
+				Params:   map[string]json.RawMessage{"per_page": json.RawMessage(`200`), "page": json.RawMessage(`2`)},
+			},
+		},
+		{
+			name:  "no params",
+			ds:    ds,
+			query: &StravaAPIRequest{Endpoint: "athlete/activities"},
+		},
+		{
+			name: "other athlete",
+			ds:   ds,
+			query: &StravaAPIRequest{
+				Endpoint:  "athlete/activities",
+				Params:    map[string]json.RawMessage{"per_page": json.RawMessage(`200`), "page": json.RawMessage(`1`)},
+				AthleteId: 42,
+			},
+		},
+		{
+			name: "other data source",
+			ds:   otherDs,
+			query: &StravaAPIRequest{
+				Endpoint: "athlete/activities",
+				Params:   map[string]json.RawMessage{"per_page": json.RawMessage(`200`), "page": json.RawMessage(`1`)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.ds.requestCacheKey(tt.query)
+			if (key == baseKey) != tt.same {
+				t.Errorf("expected same key %v, got %s and %s", tt.same, baseKey, key)
+			}
+		})
+	}
+}
+
+func TestRequestCacheKeyAccessToken(t *testing.T) {
+	ds := &StravaDatasourceInstance{dsInfo: &backend.DataSourceInstanceSettings{ID: 1}}
+	key := func(accessToken string) string {
+		return ds.requestCacheKey(&StravaAPIRequest{Endpoint: "athlete", AccessToken: accessToken})
+	}
+
+	if key("token1") != key("token1") {
+		t.Error("expected same key for the same token")
+	}
+	if key("token1") == key("token2") {
+		t.Error("expected different keys for different tokens")
+	}
+	if key("token1") == key("") {
+		t.Error("expected forwarded token key to differ from data source token key")
+	}
+}
+
+func TestPassThroughCacheKey(t *testing.T) {
+	if key := passThroughCacheKey("hash", ""); key != "hash" {
+		t.Errorf("expected unscoped key for data source token, got %s", key)
+	}
+	if key := passThroughCacheKey("hash", "token"); key != "hash:"+HashString("token") {
+		t.Errorf("expected key scoped to forwarded token, got %s", key)
+	}
+}
+
+func TestAthleteCacheKey(t *testing.T) {
+	if key := athleteCacheKey("hash", DefaultAthleteId); key != "hash" {
+		t.Errorf("expected unscoped key for default athlete, got %s", key)
+	}
+	if key := athleteCacheKey("hash", 42); key != "42:hash" {
+		t.Errorf("expected key scoped to athlete, got %s", key)
+	}
+}
@@ -0,0 +1,94 @@
+package datasource
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+)
+
+// CachePolicySettings defines how responses of the endpoints matching pattern are cached. Empty TTL means
+// data source cache TTL.
+type CachePolicySettings struct {
+	Pattern              string `json:"pattern"`
+	TTL                  string `json:"ttl,omitempty"`
+	Persistent           bool   `json:"persistent,omitempty"`
+	StaleWhileRevalidate string `json:"staleWhileRevalidate,omitempty"`
+}
+
+// DefaultCachePolicies are applied after policies from the data source settings. Completed activities,
+// their streams, laps, zones and segments rarely change, while list of activities is updated with each
+// new activity and comments and kudos are added by other athletes.
+var DefaultCachePolicies = []CachePolicySettings{
+	{Pattern: `^activities/\d+/streams`, TTL: "7d", Persistent: true},
+	{Pattern: `^activities/\d+/(laps|zones)$`, TTL: "1d", Persistent: true},
+	{Pattern: `^activities/\d+/(comments|kudos)$`},
+	{Pattern: `^activities/\d+$`, TTL: "1d", Persistent: true},
+	{Pattern: `^segments/\d+`, TTL: "1d", Persistent: true},
+	{Pattern: `^athlete/activities$`, StaleWhileRevalidate: "1h"},
+	{Pattern: `^athlete$`, Persistent: true},
+	{Pattern: `^athletes/\d+/stats`},
+}
+
+// CachePolicy is a parsed cache policy
+type CachePolicy struct {
+	Pattern              *regexp.Regexp
+	TTL                  time.Duration
+	Persistent           bool
+	StaleWhileRevalidate time.Duration
+}
+
+// CachePolicies is an ordered list of cache policies, first matching policy is applied
+type CachePolicies []CachePolicy
+
+// NewCachePolicies parses policies from the data source settings followed by the default ones
+func NewCachePolicies(settings []CachePolicySettings, defaultTTL time.Duration) (CachePolicies, error) {
+	policies := make(CachePolicies, 0, len(settings)+len(DefaultCachePolicies))
+	for _, s := range append(settings, DefaultCachePolicies...) {
+		policy, err := parseCachePolicy(s, defaultTTL)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+func parseCachePolicy(s CachePolicySettings, defaultTTL time.Duration) (CachePolicy, error) {
+	pattern, err := regexp.Compile(s.Pattern)
+	if err != nil {
+		return CachePolicy{}, fmt.Errorf("invalid cache policy pattern %q: %w", s.Pattern, err)
+	}
+
+	policy := CachePolicy{
+		Pattern:    pattern,
+		TTL:        defaultTTL,
+		Persistent: s.Persistent,
+	}
+	if s.TTL != "" {
+		policy.TTL, err = gtime.ParseInterval(s.TTL)
+		if err != nil {
+			return CachePolicy{}, fmt.Errorf("invalid cache policy TTL %q: %w", s.TTL, err)
+		}
+	}
+	if s.StaleWhileRevalidate != "" {
+		policy.StaleWhileRevalidate, err = gtime.ParseInterval(s.StaleWhileRevalidate)
+		if err != nil {
+			return CachePolicy{}, fmt.Errorf("invalid cache policy stale-while-revalidate %q: %w", s.StaleWhileRevalidate, err)
+		}
+	}
+	return policy, nil
+}
+
+// Match returns policy for the endpoint, responses of endpoints without policy are not cached
+func (p CachePolicies) Match(endpoint string) (*CachePolicy, bool) {
+	endpoint = strings.Trim(endpoint, "/")
+	for i := range p {
+		if p[i].Pattern.MatchString(endpoint) {
+			return &p[i], true
+		}
+	}
+	return nil, false
+}
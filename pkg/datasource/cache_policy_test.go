@@ -0,0 +1,73 @@
+package datasource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCachePoliciesMatch(t *testing.T) {
+	settings := []CachePolicySettings{
+		{Pattern: `^athlete/activities$`, TTL: "5m"},
+		{Pattern: `^athlete/zones$`},
+	}
+	policies, err := NewCachePolicies(settings, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		endpoint   string
+		ok         bool
+		pattern    string
+		ttl        time.Duration
+		persistent bool
+	}{
+		{endpoint: "athlete/activities", ok: true, pattern: `^athlete/activities$`, ttl: 5 * time.Minute},
+		{endpoint: "/athlete/zones/", ok: true, pattern: `^athlete/zones$`, ttl: time.Hour},
+		{endpoint: "athlete", ok: true, pattern: `^athlete$`, ttl: time.Hour, persistent: true},
+		{endpoint: "activities/123", ok: true, pattern: `^activities/\d+$`, ttl: 24 * time.Hour, persistent: true},
+		{endpoint: "activities/123/streams/time,heartrate", ok: true, pattern: `^activities/\d+/streams`, ttl: 7 * 24 * time.Hour, persistent: true},
+		{endpoint: "activities/123/laps", ok: true, pattern: `^activities/\d+/(laps|zones)$`, ttl: 24 * time.Hour, persistent: true},
+		{endpoint: "activities/123/zones", ok: true, pattern: `^activities/\d+/(laps|zones)$`, ttl: 24 * time.Hour, persistent: true},
+		{endpoint: "activities/123/kudos", ok: true, pattern: `^activities/\d+/(comments|kudos)$`, ttl: time.Hour},
+		{endpoint: "segments/42", ok: true, pattern: `^segments/\d+`, ttl: 24 * time.Hour, persistent: true},
+		{endpoint: "athletes/1/stats", ok: true, pattern: `^athletes/\d+/stats`, ttl: time.Hour},
+		{endpoint: "activities/abc", ok: false},
+		{endpoint: "uploads/1", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			policy, ok := policies.Match(tt.endpoint)
+			if ok != tt.ok {
+				t.Fatalf("expected match %v, got %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if policy.Pattern.String() != tt.pattern || policy.TTL != tt.ttl || policy.Persistent != tt.persistent {
+				t.Errorf("expected %s (%v, persistent %v), got %s (%v, persistent %v)",
+					tt.pattern, tt.ttl, tt.persistent, policy.Pattern, policy.TTL, policy.Persistent)
+			}
+		})
+	}
+}
+
+func TestNewCachePoliciesInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings CachePolicySettings
+	}{
+		{name: "pattern", settings: CachePolicySettings{Pattern: `^activities/(`}},
+		{name: "ttl", settings: CachePolicySettings{Pattern: `^athlete$`, TTL: "soon"}},
+		{name: "stale while revalidate", settings: CachePolicySettings{Pattern: `^athlete$`, StaleWhileRevalidate: "1x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCachePolicies([]CachePolicySettings{tt.settings}, time.Hour); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
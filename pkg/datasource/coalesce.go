@@ -0,0 +1,55 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// SharedRequestTimeout limits upstream request shared by the concurrent callers. Shared request is not
+// bound to the context of the caller started it, so it's not cancelled if that caller goes away, only when
+// data source instance is disposed.
+const SharedRequestTimeout = 2 * time.Minute
+
+// queryShared makes API request once for all concurrent callers with the same request hash. If policy is
+// set, response is saved to the cache before it's returned to the waiters.
+func (ds *StravaDatasourceInstance) queryShared(ctx context.Context, requestHash string, query *StravaAPIRequest, policy *CachePolicy) (*StravaApiResourceResponse, error) {
+	sharedQuery := *query
+	resultCh := ds.requests.DoChan(requestHash, func() (result interface{}, err error) {
+		// Shared call runs in its own goroutine, so panic can't be recovered by the callers
+		defer func() {
+			if r := recover(); r != nil {
+				ds.logger.Error("Strava API request panic", "endpoint", sharedQuery.Endpoint, "panic", r, "stack", string(debug.Stack()))
+				err = fmt.Errorf("request to %s failed: %v", sharedQuery.Endpoint, r)
+			}
+		}()
+
+		sharedCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), SharedRequestTimeout)
+		defer cancel()
+		stop := context.AfterFunc(ds.ctx, cancel)
+		defer stop()
+
+		response, err := ds.StravaAPIQuery(sharedCtx, &sharedQuery)
+		if err != nil {
+			return nil, err
+		}
+		if policy != nil {
+			ds.cache.SetResponse(requestHash, sharedQuery.Endpoint, response, policy)
+		}
+		return response, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-resultCh:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		if result.Shared {
+			ds.logger.Debug("Request shared with concurrent callers", "endpoint", query.Endpoint)
+		}
+		return result.Val.(*StravaApiResourceResponse), nil
+	}
+}
@@ -0,0 +1,131 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// waitingContext reports when the caller of queryShared starts waiting for the result, i.e. after its request
+// has joined or started the shared call
+type waitingContext struct {
+	context.Context
+	once    sync.Once
+	waiting func()
+}
+
+func (c *waitingContext) Done() <-chan struct{} {
+	c.once.Do(c.waiting)
+	return c.Context.Done()
+}
+
+func TestQuerySharedAccessToken(t *testing.T) {
+	var mu sync.Mutex
+	tokens := make([]string, 0)
+	release := make(chan struct{})
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		tokens = append(tokens, req.Header.Get("Authorization"))
+		mu.Unlock()
+		<-release
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{}`)), Header: http.Header{}}, nil
+	})}
+
+	ds := newTestInstance(client)
+	ds.dsInfo = &backend.DataSourceInstanceSettings{ID: 1}
+	ds.ctx = context.Background()
+
+	var wg, waiting sync.WaitGroup
+	for _, accessToken := range []string{"token1", "token1", "token2"} {
+		wg.Add(1)
+		waiting.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := &waitingContext{Context: context.Background(), waiting: waiting.Done}
+			query := &StravaAPIRequest{Endpoint: "athlete", AccessToken: accessToken}
+			if _, err := ds.queryShared(ctx, ds.requestCacheKey(query), query, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// Requests are blocked until all callers have joined the shared calls
+	waiting.Wait()
+	close(release)
+	wg.Wait()
+
+	slices.Sort(tokens)
+	if expected := []string{"Bearer token1", "Bearer token2"}; !slices.Equal(tokens, expected) {
+		t.Errorf("expected requests with %v, got %v", expected, tokens)
+	}
+}
+
+func TestStravaAPIQueryWithCacheAccessToken(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		body := fmt.Sprintf(`{"token": %q}`, req.Header.Get("Authorization"))
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Header: http.Header{}}, nil
+	})}
+
+	dsInfo := &backend.DataSourceInstanceSettings{ID: 1, UID: fmt.Sprintf("test-%d", time.Now().UnixNano())}
+	policies, err := NewCachePolicies(nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := newTestInstance(client)
+	ds.dsInfo = dsInfo
+	ds.ctx = context.Background()
+	ds.cachePolicies = policies
+	ds.cache = NewDSCache(dsInfo, time.Hour, 0, t.TempDir(), 0, 0)
+	if err := ds.cache.EnablePersistence(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	defer ds.cache.Stop()
+	ds.cache.Set(accessTokenCacheKey(DefaultAthleteId), "token")
+
+	for _, accessToken := range []string{"token1", "token2", "", "token1", "token2", ""} {
+		response, err := ds.StravaAPIQueryWithCache(context.Background(), &StravaAPIRequest{Endpoint: "athlete", AccessToken: accessToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "Bearer " + accessToken
+		if accessToken == "" {
+			expected = "Bearer token"
+		}
+		if token := response.Result.(map[string]interface{})["token"]; token != expected {
+			t.Errorf("expected response requested with %s, got %v", expected, token)
+		}
+	}
+
+	if requests != 3 {
+		t.Errorf("expected request per token, got %d", requests)
+	}
+	// Only responses requested with data source token are saved to disk
+	persistent := 0
+	for _, entry := range ds.cache.Entries() {
+		if entry.Persistent {
+			persistent++
+		}
+	}
+	if persistent != 1 {
+		t.Errorf("expected 1 persistent entry, got %d", persistent)
+	}
+}
@@ -1,7 +1,6 @@
 package datasource
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
@@ -11,14 +10,15 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"regexp"
-	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	simplejson "github.com/bitly/go-simplejson"
 	"github.com/grafana/strava-datasource/pkg/grafanaclient"
 	"golang.org/x/net/context/ctxhttp"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
@@ -30,11 +30,12 @@ import (
 const StravaAPIUrl = "https://www.strava.com/api/v3"
 const StravaAPITokenUrl = "https://www.strava.com/api/v3/oauth/token"
 
+// AccessTokenRefreshMargin defines how long before expiration access token is refreshed
+const AccessTokenRefreshMargin = 5 * time.Minute
+
 const StravaApiQueryType = "stravaAPI"
 const StravaAuthQueryType = "stravaAuth"
 
-var ErrAlertingNotSupported = errors.New("alerting not supported")
-
 type StravaDatasourcePlugin struct {
 	im      instancemgmt.InstanceManager
 	dataDir string
@@ -46,12 +47,36 @@ type StravaDatasourcePlugin struct {
 // and provides methods to make requests to the Strava API
 type StravaDatasourceInstance struct {
 	dsInfo        *backend.DataSourceInstanceSettings
+	settings      *StravaDatasourceSettingsDTO
 	cache         *DSCache
+	cachePolicies CachePolicies
 	logger        log.Logger
 	httpClient    *http.Client
 	prefetcher    *StravaPrefetcher
 	saToken       string
 	grafanaClient grafanaclient.GrafanaHTTPClient
+	tokenStore    TokenStore
+	rateLimiter   *RateLimiter
+	activityStore *ActivityStore
+
+	// activityFeed delivers new activities to the Grafana Live stream subscribers
+	activityFeed *ActivityFeed
+
+	// webhookSubscription is a push subscription which webhook events are accepted from
+	webhookSubscription webhookSubscription
+
+	// tokenMu serializes access token refreshes and refresh token updates
+	tokenMu sync.Mutex
+
+	// revalidating contains cache keys of the stale responses being refreshed
+	revalidating sync.Map
+
+	// requests coalesces concurrent API requests with the same request hash
+	requests singleflight.Group
+
+	// ctx is cancelled when instance is disposed, it stops background tasks and shared requests
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func NewStravaDatasourcePlugin(dataDir string, saToken string) *StravaDatasourcePlugin {
@@ -90,17 +115,40 @@ func newStravaDatasourceInstance(ctx context.Context, settings backend.DataSourc
 		log.DefaultLogger.Warn("Cannot read cache TTL", "error", err)
 	}
 
+	cachePolicies, err := NewCachePolicies(settingsDTO.CachePolicies, cacheTTL)
+	if err != nil {
+		log.DefaultLogger.Warn("Cannot read cache policies, using default ones", "error", err)
+		cachePolicies, _ = NewCachePolicies(nil, cacheTTL)
+	}
+
+	cacheMaxEntries := settingsDTO.CacheMaxEntries
+	if cacheMaxEntries <= 0 {
+		cacheMaxEntries = DefaultCacheMaxEntries
+	}
+	cacheMaxSizeMB := settingsDTO.CacheMaxSizeMB
+	if cacheMaxSizeMB <= 0 {
+		cacheMaxSizeMB = DefaultCacheMaxSizeMB
+	}
+
 	grafanaClient, err := grafanaclient.NewGrafanaHTTPClient(ctx, settings, saToken)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create grafana client: %w", err)
 	}
 
+	instanceCtx, cancel := context.WithCancel(context.Background())
 	dsInstance := &StravaDatasourceInstance{
+		ctx:           instanceCtx,
+		cancel:        cancel,
 		dsInfo:        &settings,
+		settings:      settingsDTO,
 		logger:        logger,
-		cache:         NewDSCache(&settings, cacheTTL, 10*time.Minute, dataDir),
+		cachePolicies: cachePolicies,
+		cache:         NewDSCache(&settings, cacheTTL, 10*time.Minute, dataDir, cacheMaxEntries, int64(cacheMaxSizeMB)*1024*1024),
 		saToken:       saToken,
 		grafanaClient: grafanaClient,
+		tokenStore:    NewTokenStore(settingsDTO.TokenStore, &settings, grafanaClient, saToken, dataDir),
+		rateLimiter:   getRateLimiter(settingsDTO.ClientID),
+		activityFeed:  getActivityFeed(settings.UID),
 		httpClient: &http.Client{
 			Transport: &http.Transport{
 				TLSClientConfig: &tls.Config{
@@ -120,10 +168,30 @@ func newStravaDatasourceInstance(ctx context.Context, settings backend.DataSourc
 		},
 	}
 
+	if settingsDTO.DiskCacheSizeMB >= 0 {
+		sizeMB := settingsDTO.DiskCacheSizeMB
+		if sizeMB == 0 {
+			sizeMB = DefaultDiskCacheSizeMB
+		}
+		err := dsInstance.cache.EnablePersistence(int64(sizeMB) * 1024 * 1024)
+		if err != nil {
+			logger.Warn("Cannot enable persistent cache", "error", err)
+		}
+	}
+
 	oauthPassThru := isOAuthPassThruEnabled(dsInstance)
-	if !oauthPassThru {
-		// Initialize and run prefetcher
-		prefetcher := NewStravaPrefetcher(5, dsInstance)
+	if settingsDTO.ActivityStore && !oauthPassThru && settingsDTO.SyncDepth >= 0 && dataDir != "" {
+		store, err := openActivityStore(filepath.Join(dataDir, fmt.Sprintf("%d-activities.db", settings.ID)))
+		if err != nil {
+			logger.Warn("Cannot open activity store", "error", err)
+		} else {
+			dsInstance.activityStore = store
+		}
+	}
+
+	if !oauthPassThru && settingsDTO.SyncDepth >= 0 {
+		// Initialize and run background sync
+		prefetcher := NewStravaPrefetcher(dsInstance, settingsDTO, dataDir)
 		dsInstance.prefetcher = prefetcher
 		go func() {
 			dsInstance.prefetcher.Run()
@@ -133,6 +201,20 @@ func newStravaDatasourceInstance(ctx context.Context, settings backend.DataSourc
 	return dsInstance, nil
 }
 
+// Dispose is called by instance manager when data source settings are changed or data source is removed. It
+// stops prefetcher, cancels background and in-flight API requests, stops cache janitor and releases activity
+// store and feed.
+func (ds *StravaDatasourceInstance) Dispose() {
+	ds.logger.Debug("Disposing data source instance", "data source", ds.dsInfo.Name)
+	ds.cancel()
+	ds.cache.Stop()
+	if ds.activityStore != nil {
+		ds.activityStore.Release()
+	}
+	ds.activityFeed.Release()
+	ds.httpClient.CloseIdleConnections()
+}
+
 // getDSInstance Returns cached datasource or creates new one
 func (ds *StravaDatasourcePlugin) getDSInstance(ctx context.Context, pluginContext backend.PluginContext) (*StravaDatasourceInstance, error) {
 	instance, err := ds.im.Get(ctx, pluginContext)
@@ -145,10 +227,25 @@ func (ds *StravaDatasourcePlugin) getDSInstance(ctx context.Context, pluginConte
 func (ds *StravaDatasourcePlugin) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	qdr := backend.NewQueryDataResponse()
 
+	dsInstance, err := ds.getDSInstance(ctx, req.PluginContext)
+	if err != nil {
+		ds.logger.Error("Error loading datasource", "error", err)
+		return nil, err
+	}
+
+	accessToken := ""
+	if isOAuthPassThruEnabled(dsInstance) {
+		accessToken = getBearerToken(req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName))
+	}
+
 	for _, q := range req.Queries {
-		res := backend.DataResponse{}
-		res.Error = ErrAlertingNotSupported
-		qdr.Responses[q.RefID] = res
+		query, err := ReadQuery(q)
+		if err != nil {
+			qdr.Responses[q.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+		query.AccessToken = accessToken
+		qdr.Responses[q.RefID] = dsInstance.Query(ctx, query)
 	}
 
 	return qdr, nil
@@ -158,7 +255,7 @@ func (ds *StravaDatasourcePlugin) QueryData(ctx context.Context, req *backend.Qu
 func (ds *StravaDatasourcePlugin) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
 	res := &backend.CheckHealthResult{}
 
-	_, err := ds.getDSInstance(ctx, req.PluginContext)
+	dsInstance, err := ds.getDSInstance(ctx, req.PluginContext)
 	if err != nil {
 		res.Status = backend.HealthStatusError
 		res.Message = "Error getting datasource instance"
@@ -166,22 +263,29 @@ func (ds *StravaDatasourcePlugin) CheckHealth(ctx context.Context, req *backend.
 		return res, nil
 	}
 
-	// TODO: implement real API health check
-	res.Status = backend.HealthStatusOk
-	res.Message = "Plugin up and running"
-	return res, nil
+	accessToken := ""
+	oauthPassThru := isOAuthPassThruEnabled(dsInstance)
+	if oauthPassThru {
+		accessToken = getBearerToken(req.GetHTTPHeader(backend.OAuthIdentityTokenHeaderName))
+	}
+
+	return dsInstance.CheckHealth(ctx, oauthPassThru, accessToken), nil
 }
 
 func (ds *StravaDatasourceInstance) StravaAuthQuery(ctx context.Context, req *StravaAuthRequest) (*StravaAuthResourceResponse, error) {
 	ds.logger.Debug("Performing authentication")
 	authCode := req.AuthCode
-	_, err := ds.ExchangeToken(authCode)
+	tokenResp, err := ds.ExchangeToken(authCode, req.AddAthlete)
 	if err != nil {
 		return nil, err
 	}
+	if !req.AddAthlete && tokenResp.StravaAthleteId != 0 {
+		ds.setDefaultAthlete(tokenResp.StravaAthleteId)
+	}
 
-	tokenExchangeResp := map[string]string{
-		"message": "Authorization code successfully exchanged for refresh token",
+	tokenExchangeResp := map[string]interface{}{
+		"message":   "Authorization code successfully exchanged for refresh token",
+		"athleteId": tokenResp.AthleteId,
 	}
 
 	response := &StravaAuthResourceResponse{
@@ -190,90 +294,102 @@ func (ds *StravaDatasourceInstance) StravaAuthQuery(ctx context.Context, req *St
 	return response, nil
 }
 
-func (ds *StravaDatasourceInstance) GetAccessToken() (string, error) {
-	accessToken, expTime, found := ds.cache.gocache.GetWithExpiration("accessToken")
-	accessTokenExpired := time.Now().After(expTime)
-	if found && !accessTokenExpired {
-		return accessToken.(string), nil
-	} else if found && accessTokenExpired {
-		ds.logger.Debug("Access token expired, obtaining new one")
+// GetAccessToken returns cached access token of the athlete or refreshes it if token is about to expire.
+// Only one refresh is performed at a time, concurrent callers wait for it and get the refreshed token.
+func (ds *StravaDatasourceInstance) GetAccessToken(athleteId int64) (string, error) {
+	if accessToken, ok := ds.getCachedAccessToken(athleteId, AccessTokenRefreshMargin); ok {
+		return accessToken, nil
+	}
+
+	ds.tokenMu.Lock()
+	defer ds.tokenMu.Unlock()
+
+	// Token could be refreshed by another caller while waiting for the lock
+	if accessToken, ok := ds.getCachedAccessToken(athleteId, AccessTokenRefreshMargin); ok {
+		return accessToken, nil
 	}
+	ds.logger.Debug("Access token expired or about to expire, obtaining new one", "athleteId", athleteId)
 
-	refreshToken, err := ds.GetRefreshToken()
+	refreshToken, err := ds.GetRefreshToken(athleteId)
 	if err != nil {
 		return "", err
 	}
 
-	tokenResp, err := ds.RefreshAccessToken(refreshToken)
+	tokenResp, err := ds.RefreshAccessToken(athleteId, refreshToken)
 	if err != nil {
 		ds.logger.Error(err.Error())
+		// Proactive refresh failed, but current token still can be used until it expires
+		if accessToken, ok := ds.getCachedAccessToken(athleteId, 0); ok {
+			ds.logger.Warn("Using current access token until it expires")
+			return accessToken, nil
+		}
 		return "", err
 	}
 
 	return tokenResp.AccessToken, nil
 }
 
-func (ds *StravaDatasourceInstance) GetRefreshToken() (string, error) {
-	cachedToken, found := ds.cache.Get("refreshToken")
-	if found {
-		ds.logger.Debug("Got refresh token from cache")
-		return cachedToken.(string), nil
+// getCachedAccessToken returns cached access token of the athlete if it's valid for at least minTTL
+func (ds *StravaDatasourceInstance) getCachedAccessToken(athleteId int64, minTTL time.Duration) (string, bool) {
+	accessToken, expTime, found := ds.cache.GetWithExpiration(accessTokenCacheKey(athleteId))
+	if !found {
+		return "", false
 	}
-
-	secureJsonData := ds.dsInfo.DecryptedSecureJSONData
-	refreshToken := secureJsonData["refreshToken"]
-	if refreshToken == "" {
-		ds.logger.Error("Error loading refresh token")
-		return "", errors.New("Refresh token not found, authorize datasource first")
+	if !expTime.IsZero() && time.Until(expTime) <= minTTL {
+		return "", false
 	}
-
-	ds.logger.Debug("Got refresh token from secureJsonData")
-	return refreshToken, nil
+	return accessToken.(string), true
 }
 
-// SaveRefreshToken saves refresh token in secureJsonData by calling update data source API endpoint
-func (ds *StravaDatasourceInstance) SaveRefreshToken(token string) error {
-	ds.logger.Debug("saving refresh token")
-	ds.cache.SetWithNoExpiration("refreshToken", token)
-
-	res, err := ds.grafanaClient.Get(fmt.Sprintf("/api/datasources/uid/%s", ds.dsInfo.UID))
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return err
+// accessTokenCacheKey returns cache key of the athlete access token
+func accessTokenCacheKey(athleteId int64) string {
+	if athleteId == DefaultAthleteId {
+		return "accessToken"
 	}
+	return fmt.Sprintf("accessToken:%d", athleteId)
+}
 
-	jsonData := make(map[string]any)
-	err = json.Unmarshal(body, &jsonData)
-	if err != nil {
-		return err
+// athleteCacheKey scopes cache key of the API request to the athlete whose token is used
+func athleteCacheKey(requestHash string, athleteId int64) string {
+	if athleteId == DefaultAthleteId {
+		return requestHash
 	}
-	secureJsonData := make(map[string]string)
-	secureJsonData["refreshToken"] = token
-	jsonData["secureJsonData"] = secureJsonData
+	return fmt.Sprintf("%d:%s", athleteId, requestHash)
+}
 
-	updatedData, err := json.Marshal(jsonData)
+func (ds *StravaDatasourceInstance) GetRefreshToken(athleteId int64) (string, error) {
+	refreshToken, err := ds.tokenStore.GetRefreshToken(context.Background(), athleteId)
 	if err != nil {
-		return err
+		ds.logger.Error("Error loading refresh token", "athleteId", athleteId, "error", err)
+		if athleteId != DefaultAthleteId && errors.Is(err, ErrRefreshTokenNotFound) {
+			return "", fmt.Errorf("athlete %d is not authorized: %w", athleteId, err)
+		}
+		return "", err
 	}
 
-	reqData := bytes.NewReader(updatedData)
-	updateRes, err := ds.grafanaClient.DoRequest("PUT", fmt.Sprintf("/api/datasources/uid/%s", ds.dsInfo.UID), reqData)
-	if err != nil {
-		return err
-	}
-	ds.logger.Debug("data source updated", "status", updateRes.Status)
+	ds.logger.Debug("Got refresh token from token store")
+	return refreshToken, nil
+}
 
-	return nil
+// SaveRefreshToken saves refresh token of the athlete in the configured token store
+func (ds *StravaDatasourceInstance) SaveRefreshToken(athleteId int64, token string) error {
+	ds.logger.Debug("saving refresh token", "athleteId", athleteId)
+	return ds.tokenStore.SaveRefreshToken(context.Background(), athleteId, token)
+}
+
+// GetAthletes returns ids of the additional athletes authorized in the data source
+func (ds *StravaDatasourceInstance) GetAthletes(ctx context.Context) ([]int64, error) {
+	return ds.tokenStore.Athletes(ctx)
 }
 
 // ExchangeToken invokes first time when authentication required and exchange authorization code for the
-// access and refresh tokens
+// access and refresh tokens. If addAthlete is set, tokens are stored for the authorized athlete in addition
+// to the default one.
 // https://developers.strava.com/docs/authentication/#tokenexchange
-func (ds *StravaDatasourceInstance) ExchangeToken(authCode string) (*TokenExchangeResponse, error) {
+func (ds *StravaDatasourceInstance) ExchangeToken(authCode string, addAthlete bool) (*TokenExchangeResponse, error) {
+	ds.tokenMu.Lock()
+	defer ds.tokenMu.Unlock()
+
 	jsonDataStr := ds.dsInfo.JSONData
 	jsonData, err := simplejson.NewJson([]byte(jsonDataStr))
 	if err != nil {
@@ -313,10 +429,18 @@ func (ds *StravaDatasourceInstance) ExchangeToken(authCode string) (*TokenExchan
 	accessTokenExpAt := respJson.Get("expires_at").MustInt64()
 	accessTokenExpIn := time.Until(time.Unix(accessTokenExpAt, 0))
 	refreshToken := respJson.Get("refresh_token").MustString()
-	ds.logger.Debug("Got new refresh token")
+	stravaAthleteId := respJson.GetPath("athlete", "id").MustInt64()
+	athleteId := DefaultAthleteId
+	if addAthlete {
+		athleteId = stravaAthleteId
+		if athleteId == 0 {
+			return nil, errors.New("Auth error: athlete is missing in token exchange response")
+		}
+	}
+	ds.logger.Debug("Got new refresh token", "athleteId", athleteId)
 
-	ds.cache.SetWithExpiration("accessToken", accessToken, accessTokenExpIn)
-	err = ds.SaveRefreshToken(refreshToken)
+	ds.cache.SetWithExpiration(accessTokenCacheKey(athleteId), accessToken, accessTokenExpIn)
+	err = ds.SaveRefreshToken(athleteId, refreshToken)
 	if err != nil {
 		ds.logger.Error("Error saving refresh token", "err", err)
 		return nil, fmt.Errorf("Error saving refresh token: %v", err)
@@ -326,12 +450,15 @@ func (ds *StravaDatasourceInstance) ExchangeToken(authCode string) (*TokenExchan
 		AccessToken:      accessToken,
 		AccessTokenExpAt: accessTokenExpAt,
 		RefreshToken:     refreshToken,
+		AthleteId:        athleteId,
+		StravaAthleteId:  stravaAthleteId,
 	}, nil
 }
 
-// RefreshAccessToken refreshes expired Access token using refresh token
+// RefreshAccessToken refreshes expired Access token using refresh token. Caller should hold tokenMu,
+// use GetAccessToken to get valid token.
 // https://developers.strava.com/docs/authentication/#refreshingexpiredaccesstokens
-func (ds *StravaDatasourceInstance) RefreshAccessToken(refreshToken string) (*TokenExchangeResponse, error) {
+func (ds *StravaDatasourceInstance) RefreshAccessToken(athleteId int64, refreshToken string) (*TokenExchangeResponse, error) {
 	jsonDataStr := ds.dsInfo.JSONData
 	jsonData, err := simplejson.NewJson([]byte(jsonDataStr))
 	if err != nil {
@@ -353,9 +480,6 @@ func (ds *StravaDatasourceInstance) RefreshAccessToken(refreshToken string) (*To
 	if err != nil {
 		return nil, err
 	}
-	if authResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Token exchange failed: %v", authResp.Status)
-	}
 
 	defer authResp.Body.Close()
 	body, err := io.ReadAll(authResp.Body)
@@ -363,6 +487,13 @@ func (ds *StravaDatasourceInstance) RefreshAccessToken(refreshToken string) (*To
 		return nil, err
 	}
 
+	if authResp.StatusCode != http.StatusOK {
+		if authErr := authErrorFromFault(parseStravaFault(body)); authErr != nil {
+			return nil, fmt.Errorf("Token exchange failed: %w", authErr)
+		}
+		return nil, fmt.Errorf("Token exchange failed: %v", authResp.Status)
+	}
+
 	respJson, err := simplejson.NewJson(body)
 	if err != nil {
 		return nil, err
@@ -373,11 +504,11 @@ func (ds *StravaDatasourceInstance) RefreshAccessToken(refreshToken string) (*To
 	accessTokenExpIn := time.Until(time.Unix(accessTokenExpAt, 0))
 	refreshTokenNew := respJson.Get("refresh_token").MustString()
 
-	ds.cache.SetWithExpiration("accessToken", accessToken, accessTokenExpIn)
+	ds.cache.SetWithExpiration(accessTokenCacheKey(athleteId), accessToken, accessTokenExpIn)
 	if refreshTokenNew != refreshToken {
-		ds.logger.Debug("Got new refresh token")
+		ds.logger.Debug("Got new refresh token", "athleteId", athleteId)
 
-		err := ds.SaveRefreshToken(refreshToken)
+		err := ds.SaveRefreshToken(athleteId, refreshTokenNew)
 		if err != nil {
 			ds.logger.Error("Error saving refresh token", "err", err)
 		}
@@ -387,54 +518,96 @@ func (ds *StravaDatasourceInstance) RefreshAccessToken(refreshToken string) (*To
 		AccessToken:      accessToken,
 		AccessTokenExpAt: accessTokenExpAt,
 		RefreshToken:     refreshTokenNew,
+		AthleteId:        athleteId,
 	}, nil
 }
 
 func (ds *StravaDatasourceInstance) ResetAccessToken() error {
-	ds.cache.Delete("accessToken")
-	ds.logger.Debug("Access token removed from cache")
+	ds.cache.Delete(accessTokenCacheKey(DefaultAthleteId))
+	athletes, err := ds.GetAthletes(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, athleteId := range athletes {
+		ds.cache.Delete(accessTokenCacheKey(athleteId))
+	}
+	ds.logger.Debug("Access tokens removed from cache")
 	return nil
 }
 
+// ResetCache removes cached responses along with synced activities and sync progress
 func (ds *StravaDatasourceInstance) ResetCache() {
 	ds.cache.Flush()
+	if ds.prefetcher != nil {
+		if err := ds.prefetcher.Reset(); err != nil {
+			ds.logger.Warn("Error resetting background sync", "error", err)
+		}
+	}
 	ds.logger.Info("Cache has been reset", "data source", ds.dsInfo.Name)
 }
 
-func (ds *StravaDatasourceInstance) StravaAPIQueryWithCache(requestHash string) func(context.Context, *StravaAPIRequest) (*StravaApiResourceResponse, error) {
-	cachedEndpointsPattern := regexp.MustCompile(`activities/\d+|athlete|segments/\d`)
-	return func(ctx context.Context, query *StravaAPIRequest) (*StravaApiResourceResponse, error) {
-		if cachedEndpointsPattern.MatchString(query.Endpoint) || query.Endpoint == "athlete/activities" {
-			cachedResponse, found := ds.cache.Get(requestHash)
-			if found {
-				apiResponse, ok := cachedResponse.(*StravaApiResourceResponse)
-				if ok {
-					return apiResponse, nil
-				} else {
-					ds.logger.Error("Cannot get value from cache, type assertion failed")
-				}
-			}
-			response, err := ds.StravaAPIQuery(ctx, query)
-			if err != nil {
-				return nil, err
-			}
-			if query.Endpoint == "athlete/activities" {
-				ds.cache.Set(requestHash, response)
-			} else {
-				ds.cache.Set(requestHash, response)
-			}
-			return response, nil
-		} else {
-			return ds.StravaAPIQuery(ctx, query)
+// setDefaultAthlete removes data of the previous account if default athlete is authorized with another one
+func (ds *StravaDatasourceInstance) setDefaultAthlete(athleteId int64) {
+	if ds.prefetcher == nil {
+		return
+	}
+	changed, err := ds.prefetcher.SetAthlete(athleteId)
+	if err != nil {
+		ds.logger.Warn("Error resetting data of the previous athlete", "error", err)
+	}
+	if changed {
+		ds.cache.Flush()
+		ds.logger.Info("Data source authorized with another athlete, cache has been reset", "data source", ds.dsInfo.Name)
+	}
+}
+
+// InvalidateCache removes cached responses of the endpoints matching the pattern
+func (ds *StravaDatasourceInstance) InvalidateCache(pattern *regexp.Regexp) int {
+	invalidated := ds.cache.InvalidateEndpoints(pattern)
+	ds.logger.Info("Cache invalidated", "data source", ds.dsInfo.Name, "pattern", pattern.String(), "entries", invalidated)
+	return invalidated
+}
+
+// InvalidateActivityCache removes cached activity details and streams along with the lists of activities, so
+// changes of the activity are visible in all queries. Other activities stay in cache.
+func (ds *StravaDatasourceInstance) InvalidateActivityCache(activityId int64) int {
+	pattern := regexp.MustCompile(fmt.Sprintf(`^(activities/%d(/.*)?|athlete/activities)$`, activityId))
+	return ds.InvalidateCache(pattern)
+}
+
+// StravaAPIQueryWithCache returns cached response of the API request or makes the request and caches
+// response according to the endpoint cache policy
+func (ds *StravaDatasourceInstance) StravaAPIQueryWithCache(ctx context.Context, query *StravaAPIRequest) (*StravaApiResourceResponse, error) {
+	requestHash := ds.requestCacheKey(query)
+	policy, ok := ds.cachePolicies.Match(query.Endpoint)
+	if !ok {
+		return ds.queryShared(ctx, requestHash, query, nil)
+	}
+	if query.AccessToken != "" && policy.Persistent {
+		// Forwarded tokens expire in hours, so responses cached under them aren't worth keeping on disk
+		memoryPolicy := *policy
+		memoryPolicy.Persistent = false
+		policy = &memoryPolicy
+	}
+
+	cachedResponse, stale, found := ds.cache.GetResponse(requestHash)
+	if found {
+		if !stale {
+			return cachedResponse, nil
 		}
+		// Serve stale response immediately and refresh it in background
+		ds.revalidate(requestHash, query, policy)
+		markStale(ctx)
+		return &StravaApiResourceResponse{Result: cachedResponse.Result, Stale: true}, nil
 	}
+	return ds.queryShared(ctx, requestHash, query, policy)
 }
 
 func (ds *StravaDatasourceInstance) StravaAPIQuery(ctx context.Context, query *StravaAPIRequest) (*StravaApiResourceResponse, error) {
 	accessToken := ""
 	var err error
 	if query.AccessToken == "" {
-		accessToken, err = ds.GetAccessToken()
+		accessToken, err = ds.GetAccessToken(query.AthleteId)
 		if err != nil {
 			return nil, err
 		}
@@ -445,7 +618,7 @@ func (ds *StravaDatasourceInstance) StravaAPIQuery(ctx context.Context, query *S
 	endpoint := query.Endpoint
 	params := query.Params
 
-	requestUrlStr := fmt.Sprintf("%s/%s", StravaAPIUrl, strings.TrimLeft(endpoint, "/"))
+	requestUrlStr := fmt.Sprintf("%s/%s", StravaAPIUrl, normalizeEndpoint(endpoint))
 	requestUrl, err := url.Parse(requestUrlStr)
 	if err != nil {
 		return nil, err
@@ -453,11 +626,7 @@ func (ds *StravaDatasourceInstance) StravaAPIQuery(ctx context.Context, query *S
 
 	q := requestUrl.Query()
 	for param, value := range params {
-		valueUnquoted, err := strconv.Unquote(string(value))
-		if err != nil {
-			valueUnquoted = string(value)
-		}
-		q.Add(param, valueUnquoted)
+		q.Add(param, paramValue(value))
 	}
 	requestUrl.RawQuery = q.Encode()
 	ds.logger.Debug("Strava API query", "url", requestUrl.String())
@@ -470,7 +639,7 @@ func (ds *StravaDatasourceInstance) StravaAPIQuery(ctx context.Context, query *S
 		},
 	}
 
-	apiResponse, err := makeHTTPRequest(ctx, ds.httpClient, req)
+	apiResponse, err := ds.makeHTTPRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -490,20 +659,61 @@ func BuildAPIResponse(apiResponse []byte) (*StravaApiResourceResponse, error) {
 	}, nil
 }
 
-func makeHTTPRequest(ctx context.Context, httpClient *http.Client, req *http.Request) ([]byte, error) {
-	res, err := ctxhttp.Do(ctx, httpClient, req)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
+// makeHTTPRequest performs Strava API request if it fits into rate limits and updates API usage from the
+// response headers. GET requests failed with 5xx status are retried with backoff while the context deadline
+// allows. Requests failed with 429 status are retried once the Retry-After delay passes or rate limit window
+// is reset, if it happens within RetryMaxDelay.
+func (ds *StravaDatasourceInstance) makeHTTPRequest(ctx context.Context, req *http.Request) ([]byte, error) {
+	var lastBody []byte
+	var lastErr error
+	// Rate limiter blocks requests after 429 until the window is reset, so retry of such request bypasses it
+	bypassRateLimiter := false
+
+	for attempt := 0; ; attempt++ {
+		if !bypassRateLimiter {
+			err := ds.rateLimiter.Allow(ctx)
+			if err != nil {
+				ds.logger.Warn("Request rejected by rate limiter", "url", req.URL.Path, "error", err)
+				if lastErr != nil {
+					return lastBody, lastErr
+				}
+				return nil, err
+			}
+		}
 
-	if res.StatusCode >= 400 {
-		return body, fmt.Errorf("Error status: %v", res.Status)
-	}
+		res, err := ctxhttp.Do(ctx, ds.httpClient, req)
+		if err != nil {
+			return nil, err
+		}
+		ds.rateLimiter.Update(res.Header, res.StatusCode)
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
 
-	if err != nil {
-		return nil, err
+		if res.StatusCode < 400 {
+			if err != nil {
+				return nil, err
+			}
+			return body, nil
+		}
+
+		lastBody = body
+		lastErr = newStravaAPIError(res, body)
+
+		if req.Method != http.MethodGet || !isRetryableStatus(res.StatusCode) || attempt >= MaxRetries {
+			return lastBody, lastErr
+		}
+		delay := retryDelay(attempt, res.Header)
+		bypassRateLimiter = res.StatusCode == http.StatusTooManyRequests
+		if bypassRateLimiter {
+			var ok bool
+			delay, ok = rateLimitRetryDelay(res.Header, ds.rateLimiter.ResetIn())
+			if !ok {
+				return lastBody, lastErr
+			}
+		}
+		ds.logger.Debug("Retrying Strava API request", "url", req.URL.Path, "status", res.StatusCode, "attempt", attempt+1, "delay", delay)
+		if !waitForRetry(ctx, delay) {
+			return lastBody, lastErr
+		}
 	}
-	return body, nil
 }
@@ -0,0 +1,265 @@
+package datasource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDiskCacheSizeMB is used if disk cache size is not set in the data source settings
+const DefaultDiskCacheSizeMB = 100
+
+const diskCacheFileExt = ".json"
+
+// DiskCacheEntry is a cached API response stored in the file. Entry is served as stale after StaleAt
+// and removed after ExpiresAt. Times are unix milliseconds, zero means no expiration.
+type DiskCacheEntry struct {
+	Key       string          `json:"key"`
+	Endpoint  string          `json:"endpoint,omitempty"`
+	CachedAt  int64           `json:"cachedAt,omitempty"`
+	StaleAt   int64           `json:"staleAt,omitempty"`
+	ExpiresAt int64           `json:"expiresAt"`
+	Value     json.RawMessage `json:"value"`
+}
+
+func (e *DiskCacheEntry) expired(now time.Time) bool {
+	return e.ExpiresAt > 0 && now.UnixMilli() >= e.ExpiresAt
+}
+
+// DiskCache stores API responses in the data dir, so cache survives plugin restarts. Each entry is kept in
+// a separate file, total size of the files is limited by sizeLimit, oldest entries are removed first.
+type DiskCache struct {
+	dir       string
+	sizeLimit int64
+
+	mu    sync.Mutex
+	sizes map[string]int64
+	size  int64
+}
+
+// NewDiskCache creates disk cache in the given directory and reads sizes of existing entries
+func NewDiskCache(dir string, sizeLimit int64) (*DiskCache, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{
+		dir:       dir,
+		sizeLimit: sizeLimit,
+		sizes:     make(map[string]int64),
+	}
+
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		c.sizes[f.path] = f.size
+		c.size += f.size
+	}
+	return c, nil
+}
+
+// Get returns cached entry if it exists and not expired
+func (c *DiskCache) Get(key string) (*DiskCacheEntry, bool) {
+	entry, err := c.read(c.filename(key))
+	if err != nil {
+		return nil, false
+	}
+	if entry.expired(time.Now()) {
+		c.remove(c.filename(key))
+		return nil, false
+	}
+	return entry, true
+}
+
+// Set saves entry replacing existing one with the same key
+func (c *DiskCache) Set(entry *DiskCacheEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Write to the temporary file first, so partially written entry is never read
+	filename := c.filename(entry.Key)
+	tmpFilename := filename + ".tmp"
+	err = os.WriteFile(tmpFilename, data, 0640)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFilename, filename)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.size += int64(len(data)) - c.sizes[filename]
+	c.sizes[filename] = int64(len(data))
+	overLimit := c.size > c.sizeLimit
+	c.mu.Unlock()
+
+	if overLimit {
+		c.evict()
+	}
+	return nil
+}
+
+// Delete removes entry from the disk
+func (c *DiskCache) Delete(key string) {
+	c.remove(c.filename(key))
+}
+
+// Flush removes all entries from the disk
+func (c *DiskCache) Flush() {
+	files, err := c.files()
+	if err != nil {
+		cacheLogger.Warn("Error reading disk cache", "dir", c.dir, "error", err)
+		return
+	}
+	for _, f := range files {
+		c.remove(f.path)
+	}
+}
+
+func (c *DiskCache) remove(filename string) {
+	err := os.Remove(filename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		cacheLogger.Warn("Error removing cache entry", "path", filename, "error", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size -= c.sizes[filename]
+	delete(c.sizes, filename)
+}
+
+// Size returns number of entries and their total size in bytes
+func (c *DiskCache) Size() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sizes), c.size
+}
+
+// Entries calls fn for each not expired entry
+func (c *DiskCache) Entries(fn func(entry *DiskCacheEntry)) {
+	files, err := c.files()
+	if err != nil {
+		cacheLogger.Warn("Error reading disk cache", "dir", c.dir, "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, f := range files {
+		entry, err := c.read(f.path)
+		if err != nil || entry.expired(now) {
+			c.remove(f.path)
+			continue
+		}
+		fn(entry)
+	}
+}
+
+// evict removes expired entries and then oldest ones until cache size fits into 90% of the limit
+func (c *DiskCache) evict() {
+	files, err := c.files()
+	if err != nil {
+		cacheLogger.Warn("Error reading disk cache", "dir", c.dir, "error", err)
+		return
+	}
+
+	now := time.Now()
+	remaining := make([]diskCacheFile, 0, len(files))
+	for _, f := range files {
+		entry, err := c.read(f.path)
+		if err != nil || entry.expired(now) {
+			c.remove(f.path)
+			continue
+		}
+		remaining = append(remaining, f)
+	}
+
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i].modTime.Before(remaining[j].modTime) })
+	target := c.sizeLimit * 9 / 10
+	for _, f := range remaining {
+		c.mu.Lock()
+		size := c.size
+		c.mu.Unlock()
+		if size <= target {
+			break
+		}
+		c.remove(f.path)
+	}
+	cacheLogger.Debug("Disk cache evicted", "dir", c.dir, "size", c.size)
+}
+
+type diskCacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *DiskCache) files() ([]diskCacheFile, error) {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]diskCacheFile, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), diskCacheFileExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, diskCacheFile{
+			path:    filepath.Join(c.dir, e.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return files, nil
+}
+
+func (c *DiskCache) read(filename string) (*DiskCacheEntry, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	entry := &DiskCacheEntry{}
+	err = json.Unmarshal(data, entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache entry %s: %w", filename, err)
+	}
+	return entry, nil
+}
+
+// filename returns path of the entry file. Keys are request hashes, optionally prefixed with athlete id,
+// so they're safe to use as file names.
+func (c *DiskCache) filename(key string) string {
+	return filepath.Join(c.dir, strings.ReplaceAll(key, ":", "-")+diskCacheFileExt)
+}
+
+// unixMilliTime converts entry time to time.Time, zero value is kept as zero time
+func unixMilliTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(t)
+}
+
+// timeUnixMilli converts time to entry time, zero time is kept as zero value
+func timeUnixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -0,0 +1,102 @@
+package datasource
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	entries := []*DiskCacheEntry{
+		{Key: "a1b2", Endpoint: "athlete", ExpiresAt: now.Add(time.Hour).UnixMilli(), Value: json.RawMessage(`{"id":1}`)},
+		{Key: "12345:c3d4", Endpoint: "activities/1", Value: json.RawMessage(`{"id":2}`)},
+		{Key: "expired", Endpoint: "athlete/activities", ExpiresAt: now.Add(-time.Second).UnixMilli(), Value: json.RawMessage(`[]`)},
+	}
+	for _, entry := range entries {
+		if err := c.Set(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, key := range []string{"a1b2", "12345:c3d4"} {
+		entry, ok := c.Get(key)
+		if !ok || entry.Key != key {
+			t.Errorf("expected entry %s, got %v", key, entry)
+		}
+	}
+	if _, ok := c.Get("expired"); ok {
+		t.Error("expected expired entry to be removed")
+	}
+	if _, ok := c.Get("missing"); ok {
+		t.Error("expected missing entry")
+	}
+
+	// Cache survives restart
+	c, err = NewDiskCache(dir, 1024*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, size := c.Size(); count != 2 || size == 0 {
+		t.Errorf("expected 2 entries, got %d (%d bytes)", count, size)
+	}
+	endpoints := make([]string, 0)
+	c.Entries(func(entry *DiskCacheEntry) {
+		endpoints = append(endpoints, entry.Endpoint)
+	})
+	if len(endpoints) != 2 {
+		t.Errorf("expected 2 entries, got %v", endpoints)
+	}
+
+	c.Delete("a1b2")
+	if _, ok := c.Get("a1b2"); ok {
+		t.Error("expected deleted entry")
+	}
+	c.Flush()
+	if count, size := c.Size(); count != 0 || size != 0 {
+		t.Errorf("expected empty cache, got %d entries (%d bytes)", count, size)
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	value := json.RawMessage(`"` + strings.Repeat("x", 1000) + `"`)
+	c, err := NewDiskCache(dir, 3500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	for i, key := range []string{"oldest", "older", "newer"} {
+		if err := c.Set(&DiskCacheEntry{Key: key, Value: value}); err != nil {
+			t.Fatal(err)
+		}
+		// Entries are evicted by modification time, so make order explicit
+		modTime := start.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(c.filename(key), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Set(&DiskCacheEntry{Key: "newest", Value: value}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, size := c.Size()
+	if size > 3500*9/10 {
+		t.Errorf("expected size within 90%% of the limit, got %d", size)
+	}
+	for key, expected := range map[string]bool{"oldest": false, "older": true, "newer": true, "newest": true} {
+		if _, ok := c.Get(key); ok != expected {
+			t.Errorf("entry %s: expected present %v, got %v", key, expected, ok)
+		}
+	}
+}
@@ -4,13 +4,13 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
 	"path/filepath"
+	"regexp"
 	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
-	cache "github.com/patrickmn/go-cache"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -20,83 +20,303 @@ var cacheLogger = hclog.New(&hclog.LoggerOptions{
 	Level: hclog.LevelFromString("DEBUG"),
 })
 
-// DSCache is a abstraction over go-cache.
+// DSCache is a abstraction over bounded in-memory cache with optional persistent tier on disk.
 type DSCache struct {
 	dsInfo  *backend.DataSourceInstanceSettings
-	gocache *cache.Cache
+	memory  *LRUCache
 	dataDir string
+	disk    *DiskCache
 }
 
-// NewDSCache creates a go-cache with expiration(ttl) time and cleanupInterval.
-func NewDSCache(dsInfo *backend.DataSourceInstanceSettings, ttl time.Duration, cleanupInterval time.Duration, dataDir string) *DSCache {
+// NewDSCache creates in-memory cache with expiration(ttl) time and cleanupInterval. Cache is limited by
+// maxEntries and maxBytes quotas of the data source.
+func NewDSCache(dsInfo *backend.DataSourceInstanceSettings, ttl time.Duration, cleanupInterval time.Duration, dataDir string, maxEntries int, maxBytes int64) *DSCache {
 	return &DSCache{
-		dsInfo,
-		cache.New(ttl, cleanupInterval),
-		dataDir,
+		dsInfo:  dsInfo,
+		memory:  NewLRUCache(ttl, cleanupInterval, maxEntries, maxBytes, dsInfo.UID),
+		dataDir: dataDir,
 	}
 }
 
+// EnablePersistence creates disk cache tier in the data dir limited by sizeLimit bytes and loads saved
+// responses into memory.
+func (c *DSCache) EnablePersistence(sizeLimit int64) error {
+	if c.dataDir == "" {
+		return errors.New("data dir is not configured")
+	}
+
+	disk, err := NewDiskCache(filepath.Join(c.dataDir, c.buildDSCacheKey("cache")), sizeLimit)
+	if err != nil {
+		return err
+	}
+	c.disk = disk
+
+	loaded := 0
+	disk.Entries(func(entry *DiskCacheEntry) {
+		cached, err := newCachedResponseFromDisk(entry)
+		if err != nil {
+			return
+		}
+		c.memory.Set(entry.Key, cached, expiresIn(unixMilliTime(entry.ExpiresAt)))
+		loaded++
+	})
+	cacheLogger.Info("Cache loaded from disk", "dir", disk.dir, "entries", loaded)
+	return nil
+}
+
+// cachedResponse is an API response kept in cache. Response is fresh until staleAt, then it's served as
+// stale until removed from cache.
+type cachedResponse struct {
+	response   *StravaApiResourceResponse
+	endpoint   string
+	cachedAt   time.Time
+	staleAt    time.Time
+	persistent bool
+}
+
+func (r *cachedResponse) stale(now time.Time) bool {
+	return !r.staleAt.IsZero() && now.After(r.staleAt)
+}
+
+func newCachedResponseFromDisk(entry *DiskCacheEntry) (*cachedResponse, error) {
+	response, err := BuildAPIResponse(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedResponse{
+		response:   response,
+		endpoint:   entry.Endpoint,
+		cachedAt:   unixMilliTime(entry.CachedAt),
+		staleAt:    unixMilliTime(entry.StaleAt),
+		persistent: true,
+	}, nil
+}
+
+// SetResponse adds API response of the endpoint to the cache according to the policy. Response becomes stale
+// after policy TTL and expires after stale-while-revalidate period. Persistent responses are also saved to disk
+// if persistence is enabled.
+func (c *DSCache) SetResponse(request string, endpoint string, response *StravaApiResourceResponse, policy *CachePolicy) {
+	now := time.Now()
+	staleAt := time.Time{}
+	expiresAt := time.Time{}
+	if policy.TTL > 0 {
+		staleAt = now.Add(policy.TTL)
+		expiresAt = staleAt.Add(policy.StaleWhileRevalidate)
+	}
+
+	cached := &cachedResponse{
+		response:   response,
+		endpoint:   normalizeEndpoint(endpoint),
+		cachedAt:   now,
+		staleAt:    staleAt,
+		persistent: policy.Persistent && c.disk != nil,
+	}
+	c.memory.Set(request, cached, expiresIn(expiresAt))
+	if !cached.persistent {
+		return
+	}
+
+	value, err := json.Marshal(response.Result)
+	if err != nil {
+		cacheLogger.Warn("Cannot serialize response", "key", request, "error", err)
+		return
+	}
+	err = c.disk.Set(&DiskCacheEntry{
+		Key:       request,
+		Endpoint:  cached.endpoint,
+		CachedAt:  timeUnixMilli(now),
+		StaleAt:   timeUnixMilli(staleAt),
+		ExpiresAt: timeUnixMilli(expiresAt),
+		Value:     value,
+	})
+	if err != nil {
+		cacheLogger.Warn("Error saving response to disk", "key", request, "error", err)
+	}
+}
+
+// GetResponse returns cached API response and reports if it's stale. Persisted responses missing in memory
+// are loaded from disk.
+func (c *DSCache) GetResponse(request string) (*StravaApiResourceResponse, bool, bool) {
+	value, found := c.memory.Get(request)
+	if found {
+		cached, ok := value.(*cachedResponse)
+		if !ok {
+			cacheLogger.Error("Cannot get value from cache, type assertion failed", "key", request)
+			return nil, false, false
+		}
+		return cached.response, cached.stale(time.Now()), true
+	}
+	if c.disk == nil {
+		return nil, false, false
+	}
+
+	entry, found := c.disk.Get(request)
+	if !found {
+		return nil, false, false
+	}
+	cached, err := newCachedResponseFromDisk(entry)
+	if err != nil {
+		return nil, false, false
+	}
+	c.memory.Set(request, cached, expiresIn(unixMilliTime(entry.ExpiresAt)))
+	return cached.response, cached.stale(time.Now()), true
+}
+
+// CacheEntryInfo describes cached API response
+type CacheEntryInfo struct {
+	Key        string `json:"key"`
+	Endpoint   string `json:"endpoint"`
+	SizeBytes  int64  `json:"sizeBytes"`
+	AgeSeconds int64  `json:"ageSeconds"`
+	// TTLSeconds is time left until response becomes stale, -1 if it never does
+	TTLSeconds int64 `json:"ttlSeconds"`
+	Stale      bool  `json:"stale"`
+	InMemory   bool  `json:"inMemory"`
+	Persistent bool  `json:"persistent"`
+}
+
+func newCacheEntryInfo(key string, cached *cachedResponse, size int64, inMemory bool, now time.Time) CacheEntryInfo {
+	info := CacheEntryInfo{
+		Key:        key,
+		Endpoint:   cached.endpoint,
+		SizeBytes:  size,
+		TTLSeconds: -1,
+		Stale:      cached.stale(now),
+		InMemory:   inMemory,
+		Persistent: cached.persistent,
+	}
+	if !cached.cachedAt.IsZero() {
+		info.AgeSeconds = int64(now.Sub(cached.cachedAt).Seconds())
+	}
+	if !cached.staleAt.IsZero() {
+		info.TTLSeconds = max(int64(cached.staleAt.Sub(now).Seconds()), 0)
+	}
+	return info
+}
+
+// Entries returns cached API responses, from most to least recently used ones kept in memory followed by
+// responses persisted on disk only.
+func (c *DSCache) Entries() []CacheEntryInfo {
+	now := time.Now()
+	entries := make([]CacheEntryInfo, 0)
+	inMemory := make(map[string]bool)
+	for _, item := range c.memory.Items() {
+		cached, ok := item.Value.(*cachedResponse)
+		if !ok {
+			continue
+		}
+		inMemory[item.Key] = true
+		entries = append(entries, newCacheEntryInfo(item.Key, cached, item.Size, true, now))
+	}
+	if c.disk == nil {
+		return entries
+	}
+
+	c.disk.Entries(func(entry *DiskCacheEntry) {
+		if inMemory[entry.Key] {
+			return
+		}
+		cached := &cachedResponse{
+			endpoint:   entry.Endpoint,
+			cachedAt:   unixMilliTime(entry.CachedAt),
+			staleAt:    unixMilliTime(entry.StaleAt),
+			persistent: true,
+		}
+		entries = append(entries, newCacheEntryInfo(entry.Key, cached, int64(len(entry.Value)), false, now))
+	})
+	return entries
+}
+
+// InvalidateEndpoints removes cached responses of the endpoints matching the pattern from memory and disk and
+// returns number of removed responses.
+func (c *DSCache) InvalidateEndpoints(pattern *regexp.Regexp) int {
+	keys := make(map[string]bool)
+	for _, item := range c.memory.Items() {
+		if cached, ok := item.Value.(*cachedResponse); ok && pattern.MatchString(cached.endpoint) {
+			keys[item.Key] = true
+		}
+	}
+	if c.disk != nil {
+		c.disk.Entries(func(entry *DiskCacheEntry) {
+			if pattern.MatchString(entry.Endpoint) {
+				keys[entry.Key] = true
+			}
+		})
+	}
+
+	for key := range keys {
+		c.Delete(key)
+	}
+	return len(keys)
+}
+
 // Add an item to the cache with default expiration time, replacing any existing item.
 func (c *DSCache) Set(request string, response interface{}) {
-	c.gocache.SetDefault(request, response)
+	c.memory.Set(request, response, DefaultExpiration)
 }
 
 // Save item to the cache with provided expiration time
 func (c *DSCache) SetWithExpiration(request string, response interface{}, d time.Duration) {
-	c.gocache.Set(request, response, d)
+	c.memory.Set(request, response, d)
 }
 
 // Save item to the cache with no expiration
 func (c *DSCache) SetWithNoExpiration(request string, response interface{}) {
-	c.gocache.Set(request, response, cache.NoExpiration)
+	c.memory.Set(request, response, NoExpiration)
 }
 
 // Set the value of the key "request" to "response" with default expiration time.
 func (c *DSCache) SetDefault(request string, response interface{}) {
-	c.gocache.SetDefault(request, response)
+	c.memory.Set(request, response, DefaultExpiration)
 }
 
 // Get the value associated with request from the cache
 func (c *DSCache) Get(request string) (interface{}, bool) {
-	return c.gocache.Get(request)
+	return c.memory.Get(request)
+}
+
+// GetWithExpiration returns the value from memory along with its expiration time
+func (c *DSCache) GetWithExpiration(request string) (interface{}, time.Time, bool) {
+	return c.memory.GetWithExpiration(request)
+}
+
+// Stats returns cache usage statistics
+func (c *DSCache) Stats() CacheStats {
+	stats := c.memory.Stats()
+	if c.disk != nil {
+		stats.DiskEntries, stats.DiskSizeBytes = c.disk.Size()
+	}
+	return stats
 }
 
 // Remove item from cache
 func (c *DSCache) Delete(request string) {
-	c.gocache.Delete(request)
+	c.memory.Delete(request)
+	if c.disk != nil {
+		c.disk.Delete(request)
+	}
 }
 
 // Delete all items from the cache.
 func (c *DSCache) Flush() {
-	c.gocache.Flush()
+	c.memory.Flush()
+	if c.disk != nil {
+		c.disk.Flush()
+	}
 }
 
-// Save value to disk
-func (c *DSCache) Save(request string, response interface{}) error {
-	cacheKey := c.buildDSCacheKey(request)
-	filename := filepath.Join(c.dataDir, cacheKey)
-	cacheLogger.Debug("Saving key to file", "key", request, "path", filename)
-	err := os.WriteFile(filename, []byte(response.(string)), 0644)
-	if err != nil {
-		cacheLogger.Error("Error saving data, switching to default directory", "dir", c.dataDir, "error", err)
-		c.dataDir = ""
-		return os.WriteFile(filepath.Join(c.dataDir, cacheKey), []byte(response.(string)), 0644)
-	}
-	return nil
+// Stop stops removing expired items from memory, cache should not be used after that
+func (c *DSCache) Stop() {
+	c.memory.Stop()
 }
 
-// Load value from disk
-func (c *DSCache) Load(request string) (string, error) {
-	cacheKey := c.buildDSCacheKey(request)
-	filename := filepath.Join(c.dataDir, cacheKey)
-	cacheLogger.Debug("Loading key from file", "key", request, "path", filename)
-	value, err := os.ReadFile(filename)
-	if err != nil {
-		return "", err
+// expiresIn converts expiration time to cache TTL
+func expiresIn(expiresAt time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return NoExpiration
 	}
-	response := string(value)
-	c.SetWithExpiration(request, response, cache.NoExpiration)
-	return response, nil
+	// Positive TTL is required, otherwise item is stored with default or no expiration
+	return max(time.Until(expiresAt), time.Millisecond)
 }
 
 func (c *DSCache) buildDSCacheKey(request string) string {
@@ -0,0 +1,155 @@
+package datasource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found, authorize datasource first")
+	ErrInvalidClientSecret  = errors.New("invalid client ID or client secret")
+	ErrAuthorizationRevoked = errors.New("authorization revoked or refresh token is invalid, authorize datasource again")
+	ErrInsufficientScope    = errors.New("insufficient OAuth scopes, authorize datasource again and grant access to activities")
+)
+
+// StravaFault is an error response returned by Strava API
+// https://developers.strava.com/docs/reference/#api-models-Fault
+type StravaFault struct {
+	Message string             `json:"message"`
+	Errors  []StravaFaultError `json:"errors"`
+}
+
+type StravaFaultError struct {
+	Resource string `json:"resource"`
+	Field    string `json:"field"`
+	Code     string `json:"code"`
+}
+
+// StravaAPIError is returned when Strava API responds with error status
+type StravaAPIError struct {
+	StatusCode int                `json:"status"`
+	Status     string             `json:"-"`
+	Message    string             `json:"message"`
+	Errors     []StravaFaultError `json:"errors,omitempty"`
+}
+
+// newStravaAPIError builds error from the response status and body. Body which is not a valid Strava fault
+// is used as a message.
+func newStravaAPIError(res *http.Response, body []byte) *StravaAPIError {
+	apiErr := &StravaAPIError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+	}
+	if fault := parseStravaFault(body); fault != nil {
+		apiErr.Message = fault.Message
+		apiErr.Errors = fault.Errors
+	} else {
+		apiErr.Message = truncate(strings.TrimSpace(string(body)), MaxErrorBodyLength)
+	}
+	return apiErr
+}
+
+func (e *StravaAPIError) Error() string {
+	fault := &StravaFault{Message: e.Message, Errors: e.Errors}
+	if message := fault.String(); message != "" {
+		return fmt.Sprintf("Error status: %v, %s", e.Status, message)
+	}
+	return fmt.Sprintf("Error status: %v", e.Status)
+}
+
+// Unwrap returns known authentication error described by the Strava error entries
+func (e *StravaAPIError) Unwrap() error {
+	if authErr := authErrorFromFault(&StravaFault{Message: e.Message, Errors: e.Errors}); authErr != nil {
+		return authErr
+	}
+	if e.StatusCode == http.StatusUnauthorized {
+		return ErrAuthorizationRevoked
+	}
+	return nil
+}
+
+// errorStatusCode returns HTTP status matching the error, so callers can distinguish authorization
+// problems, missing resources and exhausted rate limits from the plugin failures.
+func errorStatusCode(err error) int {
+	var apiErr *StravaAPIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusTooManyRequests:
+			return apiErr.StatusCode
+		default:
+			return http.StatusBadGateway
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrRateLimitExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrRefreshTokenNotFound), errors.Is(err, ErrInvalidClientSecret), errors.Is(err, ErrAuthorizationRevoked):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrInsufficientScope):
+		return http.StatusForbidden
+	case isNetworkError(err):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// isDownstreamError returns true if error is caused by Strava API or its availability
+func isDownstreamError(err error) bool {
+	var apiErr *StravaAPIError
+	return errors.As(err, &apiErr) || errors.Is(err, ErrRateLimitExceeded) || isNetworkError(err)
+}
+
+// String returns fault message along with error entries, like "Bad Request (Activity.id: invalid)"
+func (f *StravaFault) String() string {
+	entries := make([]string, 0, len(f.Errors))
+	for _, e := range f.Errors {
+		entries = append(entries, fmt.Sprintf("%s.%s: %s", e.Resource, e.Field, e.Code))
+	}
+	if len(entries) == 0 {
+		return f.Message
+	}
+	return fmt.Sprintf("%s (%s)", f.Message, strings.Join(entries, ", "))
+}
+
+// parseStravaFault reads Strava error response, returns nil if body is not a valid fault
+func parseStravaFault(body []byte) *StravaFault {
+	fault := &StravaFault{}
+	if err := json.Unmarshal(body, fault); err != nil {
+		return nil
+	}
+	if fault.Message == "" && len(fault.Errors) == 0 {
+		return nil
+	}
+	return fault
+}
+
+// authErrorFromFault maps Strava error entries to the known authentication errors
+func authErrorFromFault(fault *StravaFault) error {
+	if fault == nil {
+		return nil
+	}
+	for _, e := range fault.Errors {
+		switch {
+		case e.Field == "client_id" || e.Field == "client_secret":
+			return ErrInvalidClientSecret
+		case e.Field == "refresh_token" || e.Field == "access_token" || e.Resource == "RefreshToken":
+			return ErrAuthorizationRevoked
+		case strings.HasSuffix(e.Field, "permission") && e.Code == "missing":
+			return ErrInsufficientScope
+		}
+	}
+	return nil
+}
+
+// isNetworkError returns true if request failed before getting response from the server
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr)
+}
@@ -0,0 +1,133 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Health check error types reported in the health details
+const (
+	HealthErrorRefreshTokenNotFound = "refresh_token_not_found"
+	HealthErrorInvalidClientSecret  = "invalid_client_secret"
+	HealthErrorAuthorizationRevoked = "authorization_revoked"
+	HealthErrorInsufficientScope    = "insufficient_scope"
+	HealthErrorRateLimitExceeded    = "rate_limit_exceeded"
+	HealthErrorNetwork              = "network_error"
+	HealthErrorUnknown              = "unknown_error"
+)
+
+// HealthDetails contains structured results of the health check
+type HealthDetails struct {
+	AthleteId      int64  `json:"athleteId,omitempty"`
+	AthleteName    string `json:"athleteName,omitempty"`
+	ErrorType      string `json:"errorType,omitempty"`
+	VerboseMessage string `json:"verboseMessage,omitempty"`
+}
+
+// CheckHealth verifies that access token could be obtained and Strava API is available with it
+func (ds *StravaDatasourceInstance) CheckHealth(ctx context.Context, oauthPassThru bool, accessToken string) *backend.CheckHealthResult {
+	if !oauthPassThru {
+		var err error
+		accessToken, err = ds.GetAccessToken(DefaultAthleteId)
+		if err != nil {
+			ds.logger.Debug("Health check: cannot get access token", "error", err)
+			return healthError("Cannot obtain access token", err)
+		}
+	} else if accessToken == "" {
+		return healthError("OAuth identity is not forwarded", ErrRefreshTokenNotFound)
+	}
+
+	athlete := &StravaAthlete{}
+	err := ds.healthCheckRequest(ctx, "athlete", accessToken, athlete)
+	if err != nil {
+		ds.logger.Debug("Health check: cannot get authenticated athlete", "error", err)
+		return healthError("Cannot get authenticated athlete", err)
+	}
+
+	// Activities require activity:read scope which could be not granted during authorization
+	activities := make([]interface{}, 0)
+	err = ds.healthCheckRequest(ctx, "athlete/activities?per_page=1", accessToken, &activities)
+	if err != nil {
+		ds.logger.Debug("Health check: cannot get activities", "error", err)
+		return healthError("Cannot get athlete activities", err)
+	}
+
+	athleteName := fmt.Sprintf("%s %s", athlete.FirstName, athlete.LastName)
+	details, _ := json.Marshal(HealthDetails{
+		AthleteId:   athlete.Id,
+		AthleteName: athleteName,
+	})
+	return &backend.CheckHealthResult{
+		Status:      backend.HealthStatusOk,
+		Message:     fmt.Sprintf("Data source is working. Authenticated as %s.", athleteName),
+		JSONDetails: details,
+	}
+}
+
+// healthCheckRequest makes request to Strava API without cache and maps authorization errors
+func (ds *StravaDatasourceInstance) healthCheckRequest(ctx context.Context, endpoint string, accessToken string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s", StravaAPIUrl, endpoint), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	err = ds.rateLimiter.Allow(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := ds.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	ds.rateLimiter.Update(res.Header, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 400 {
+		apiErr := newStravaAPIError(res, body)
+		if authErr := apiErr.Unwrap(); authErr != nil {
+			return authErr
+		}
+		return apiErr
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+func healthError(message string, err error) *backend.CheckHealthResult {
+	errorType := HealthErrorUnknown
+	switch {
+	case errors.Is(err, ErrRefreshTokenNotFound):
+		errorType = HealthErrorRefreshTokenNotFound
+	case errors.Is(err, ErrInvalidClientSecret):
+		errorType = HealthErrorInvalidClientSecret
+	case errors.Is(err, ErrAuthorizationRevoked):
+		errorType = HealthErrorAuthorizationRevoked
+	case errors.Is(err, ErrInsufficientScope):
+		errorType = HealthErrorInsufficientScope
+	case errors.Is(err, ErrRateLimitExceeded):
+		errorType = HealthErrorRateLimitExceeded
+	case isNetworkError(err):
+		errorType = HealthErrorNetwork
+	}
+
+	details, _ := json.Marshal(HealthDetails{
+		ErrorType:      errorType,
+		VerboseMessage: err.Error(),
+	})
+	return &backend.CheckHealthResult{
+		Status:      backend.HealthStatusError,
+		Message:     fmt.Sprintf("%s: %s", message, err.Error()),
+		JSONDetails: details,
+	}
+}
@@ -0,0 +1,342 @@
+package datasource
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// NoExpiration is used to store item which never expires
+	NoExpiration time.Duration = -1
+	// DefaultExpiration is used to store item with cache default TTL
+	DefaultExpiration time.Duration = 0
+)
+
+// Default cache quotas, used if not set in the data source settings
+const (
+	DefaultCacheMaxEntries = 10000
+	DefaultCacheMaxSizeMB  = 256
+)
+
+// Estimated size of the values which are not API responses, like tokens
+const defaultEntrySize = 64
+
+var (
+	cacheHitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Name:      "strava_cache_hits_total",
+		Help:      "Number of cache hits",
+	}, []string{"datasource"})
+	cacheMissesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Name:      "strava_cache_misses_total",
+		Help:      "Number of cache misses",
+	}, []string{"datasource"})
+	cacheEvictionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Name:      "strava_cache_evictions_total",
+		Help:      "Number of items evicted from cache to fit into size limits",
+	}, []string{"datasource"})
+	cacheEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana_plugin",
+		Name:      "strava_cache_entries",
+		Help:      "Number of items in cache",
+	}, []string{"datasource"})
+	cacheSizeBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "grafana_plugin",
+		Name:      "strava_cache_size_bytes",
+		Help:      "Approximate size of items in cache",
+	}, []string{"datasource"})
+)
+
+var (
+	cacheLabelsMu sync.Mutex
+	// cacheLabels counts live caches per metrics label. Instance created for the updated data source settings
+	// reports metrics with the same label as the one it replaces, which is stopped later.
+	cacheLabels = make(map[string]int)
+)
+
+// CacheStats contains cache usage statistics
+type CacheStats struct {
+	Entries     int    `json:"entries"`
+	SizeBytes   int64  `json:"sizeBytes"`
+	MaxEntries  int    `json:"maxEntries"`
+	MaxBytes    int64  `json:"maxBytes"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+
+	DiskEntries   int   `json:"diskEntries"`
+	DiskSizeBytes int64 `json:"diskSizeBytes"`
+}
+
+type lruEntry struct {
+	key       string
+	value     interface{}
+	size      int64
+	expiresAt time.Time
+}
+
+func (e *lruEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// LRUCache is an in-memory cache bounded by number of entries and approximate size of values. Least recently
+// used entries are evicted when limits are exceeded, expired entries are removed by the janitor.
+type LRUCache struct {
+	mu         sync.Mutex
+	defaultTTL time.Duration
+	maxEntries int
+	maxBytes   int64
+	items      map[string]*list.Element
+	ll         *list.List
+	bytes      int64
+	stats      CacheStats
+	label      string
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// NewLRUCache creates cache with given limits and starts janitor removing expired entries every
+// cleanupInterval. label is used to report cache metrics.
+func NewLRUCache(defaultTTL time.Duration, cleanupInterval time.Duration, maxEntries int, maxBytes int64, label string) *LRUCache {
+	c := &LRUCache{
+		defaultTTL: defaultTTL,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		items:      make(map[string]*list.Element),
+		ll:         list.New(),
+		label:      label,
+		stop:       make(chan struct{}),
+	}
+	if cleanupInterval > 0 {
+		go c.runJanitor(cleanupInterval)
+	}
+
+	cacheLabelsMu.Lock()
+	cacheLabels[label]++
+	cacheLabelsMu.Unlock()
+	return c
+}
+
+// Set adds item to the cache replacing existing one. Use DefaultExpiration to apply cache default TTL or
+// NoExpiration to keep item until it's evicted.
+func (c *LRUCache) Set(key string, value interface{}, ttl time.Duration) {
+	if ttl == DefaultExpiration {
+		ttl = c.defaultTTL
+	}
+	entry := &lruEntry{
+		key:   key,
+		value: value,
+		size:  estimateSize(value),
+	}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.bytes += entry.size
+	c.evict()
+	c.updateGauges()
+}
+
+// Get returns item if it exists and not expired
+func (c *LRUCache) Get(key string) (interface{}, bool) {
+	value, _, found := c.GetWithExpiration(key)
+	return value, found
+}
+
+// GetWithExpiration returns item and its expiration time, zero time is returned for items without expiration
+func (c *LRUCache) GetWithExpiration(key string) (interface{}, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		cacheMissesTotal.WithLabelValues(c.label).Inc()
+		return nil, time.Time{}, false
+	}
+	entry := el.Value.(*lruEntry)
+	if entry.expired(time.Now()) {
+		c.removeElement(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		cacheMissesTotal.WithLabelValues(c.label).Inc()
+		c.updateGauges()
+		return nil, time.Time{}, false
+	}
+
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	cacheHitsTotal.WithLabelValues(c.label).Inc()
+	return entry.value, entry.expiresAt, true
+}
+
+// Delete removes item from the cache
+func (c *LRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+		c.updateGauges()
+	}
+}
+
+// Flush removes all items from the cache
+func (c *LRUCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]*list.Element)
+	c.ll.Init()
+	c.bytes = 0
+	c.updateGauges()
+}
+
+// Stats returns cache usage statistics
+func (c *LRUCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.ll.Len()
+	stats.SizeBytes = c.bytes
+	stats.MaxEntries = c.maxEntries
+	stats.MaxBytes = c.maxBytes
+	return stats
+}
+
+// LRUCacheItem is a snapshot of the cache item
+type LRUCacheItem struct {
+	Key       string
+	Value     interface{}
+	Size      int64
+	ExpiresAt time.Time
+}
+
+// Items returns not expired items ordered from most to least recently used. Unlike Get, it doesn't affect
+// usage order and statistics.
+func (c *LRUCache) Items() []LRUCacheItem {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	items := make([]LRUCacheItem, 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*lruEntry)
+		if entry.expired(now) {
+			continue
+		}
+		items = append(items, LRUCacheItem{
+			Key:       entry.key,
+			Value:     entry.value,
+			Size:      entry.size,
+			ExpiresAt: entry.expiresAt,
+		})
+	}
+	return items
+}
+
+// Stop stops the janitor and removes cache metrics, so series of the disposed data source aren't reported.
+// Metrics are kept if another live cache reports them with the same label.
+func (c *LRUCache) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+
+		cacheLabelsMu.Lock()
+		defer cacheLabelsMu.Unlock()
+		cacheLabels[c.label]--
+		if cacheLabels[c.label] > 0 {
+			return
+		}
+		delete(cacheLabels, c.label)
+		cacheHitsTotal.DeleteLabelValues(c.label)
+		cacheMissesTotal.DeleteLabelValues(c.label)
+		cacheEvictionsTotal.DeleteLabelValues(c.label)
+		cacheEntries.DeleteLabelValues(c.label)
+		cacheSizeBytes.DeleteLabelValues(c.label)
+	})
+}
+
+// DeleteExpired removes all expired items
+func (c *LRUCache) DeleteExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*lruEntry).expired(now) {
+			c.removeElement(el)
+			c.stats.Expirations++
+		}
+		el = prev
+	}
+	c.updateGauges()
+}
+
+func (c *LRUCache) runJanitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.DeleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// evict removes least recently used items until cache fits into the limits. Caller should hold the lock.
+func (c *LRUCache) evict() {
+	for c.ll.Len() > 0 && ((c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+		cacheEvictionsTotal.WithLabelValues(c.label).Inc()
+	}
+}
+
+func (c *LRUCache) removeElement(el *list.Element) {
+	entry := el.Value.(*lruEntry)
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+func (c *LRUCache) updateGauges() {
+	cacheEntries.WithLabelValues(c.label).Set(float64(c.ll.Len()))
+	cacheSizeBytes.WithLabelValues(c.label).Set(float64(c.bytes))
+}
+
+// estimateSize returns approximate memory used by the value. API responses are measured by the size of their
+// JSON representation, which is close enough to compare entries and enforce limits.
+func estimateSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	case *cachedResponse:
+		return estimateSize(v.response)
+	case *StravaApiResourceResponse:
+		data, err := json.Marshal(v.Result)
+		if err != nil {
+			return defaultEntrySize
+		}
+		return int64(len(data))
+	default:
+		return defaultEntrySize
+	}
+}
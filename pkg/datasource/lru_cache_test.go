@@ -0,0 +1,219 @@
+package datasource
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func cacheKeys(c *LRUCache) []string {
+	keys := make([]string, 0)
+	for _, item := range c.Items() {
+		keys = append(keys, item.Key)
+	}
+	return keys
+}
+
+func TestLRUCacheEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int64
+		values     []string
+		get        []string
+		expected   []string
+		evictions  uint64
+	}{
+		{
+			name:       "max entries",
+			maxEntries: 2,
+			values:     []string{"a", "b", "c"},
+			expected:   []string{"c", "b"},
+			evictions:  1,
+		},
+		{
+			name:       "recently used kept",
+			maxEntries: 2,
+			values:     []string{"a", "b"},
+			get:        []string{"a"},
+			expected:   []string{"c", "a"},
+			evictions:  1,
+		},
+		{
+			name:      "max bytes",
+			maxBytes:  25,
+			values:    []string{"a", "b", "c"},
+			expected:  []string{"c", "b"},
+			evictions: 1,
+		},
+		{
+			name:     "no limits",
+			values:   []string{"a", "b", "c"},
+			expected: []string{"c", "b", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewLRUCache(time.Hour, 0, tt.maxEntries, tt.maxBytes, "test-eviction")
+			defer c.Stop()
+
+			// Values are 10 bytes strings
+			for _, key := range tt.values {
+				c.Set(key, strings.Repeat(key, 10), DefaultExpiration)
+			}
+			for _, key := range tt.get {
+				if _, ok := c.Get(key); !ok {
+					t.Fatalf("expected %s in cache", key)
+				}
+			}
+			if len(tt.get) > 0 {
+				c.Set("c", strings.Repeat("c", 10), DefaultExpiration)
+			}
+
+			if keys := cacheKeys(c); !slices.Equal(keys, tt.expected) {
+				t.Errorf("expected keys %v, got %v", tt.expected, keys)
+			}
+			stats := c.Stats()
+			if stats.Evictions != tt.evictions {
+				t.Errorf("expected %d evictions, got %d", tt.evictions, stats.Evictions)
+			}
+			if stats.Entries != len(tt.expected) || stats.SizeBytes != int64(10*len(tt.expected)) {
+				t.Errorf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestLRUCacheReplace(t *testing.T) {
+	c := NewLRUCache(time.Hour, 0, 10, 0, "test-replace")
+	defer c.Stop()
+
+	c.Set("a", "short", DefaultExpiration)
+	c.Set("a", "longer value", DefaultExpiration)
+	value, ok := c.Get("a")
+	if !ok || value != "longer value" {
+		t.Fatalf("expected replaced value, got %v", value)
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.SizeBytes != int64(len("longer value")) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	c := NewLRUCache(50*time.Millisecond, 0, 0, 0, "test-ttl")
+	defer c.Stop()
+
+	c.Set("default", "value", DefaultExpiration)
+	c.Set("short", "value", 10*time.Millisecond)
+	c.Set("long", "value", time.Hour)
+	c.Set("never", "value", NoExpiration)
+
+	_, expiresAt, ok := c.GetWithExpiration("never")
+	if !ok || !expiresAt.IsZero() {
+		t.Errorf("expected item without expiration, got %v, %v", expiresAt, ok)
+	}
+	_, expiresAt, ok = c.GetWithExpiration("long")
+	if !ok || time.Until(expiresAt) < 59*time.Minute {
+		t.Errorf("expected item expiring in an hour, got %v, %v", expiresAt, ok)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("short"); ok {
+		t.Error("expected short item to expire")
+	}
+	if _, ok := c.Get("default"); !ok {
+		t.Error("expected default item before cache TTL")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	c.DeleteExpired()
+	if keys := cacheKeys(c); !slices.Equal(keys, []string{"long", "never"}) {
+		t.Errorf("expected not expired keys, got %v", keys)
+	}
+
+	stats := c.Stats()
+	if stats.Expirations != 2 {
+		t.Errorf("expected 2 expirations, got %d", stats.Expirations)
+	}
+	if stats.Hits != 3 || stats.Misses != 1 {
+		t.Errorf("expected 3 hits and 1 miss, got %d and %d", stats.Hits, stats.Misses)
+	}
+}
+
+func TestLRUCacheJanitor(t *testing.T) {
+	c := NewLRUCache(10*time.Millisecond, 10*time.Millisecond, 0, 0, "test-janitor")
+	defer c.Stop()
+
+	c.Set("a", "value", DefaultExpiration)
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Entries > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected janitor to remove expired item")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLRUCacheStopDeletesMetrics(t *testing.T) {
+	label := fmt.Sprintf("test-stop-%d", time.Now().UnixNano())
+	c := NewLRUCache(time.Hour, 0, 1, 0, label)
+	c.Set("a", "value", DefaultExpiration)
+	c.Set("b", "value", DefaultExpiration)
+	c.Get("a")
+	c.Get("b")
+
+	c.Stop()
+	c.Stop()
+
+	if cacheHitsTotal.DeleteLabelValues(label) || cacheMissesTotal.DeleteLabelValues(label) ||
+		cacheEvictionsTotal.DeleteLabelValues(label) || cacheEntries.DeleteLabelValues(label) ||
+		cacheSizeBytes.DeleteLabelValues(label) {
+		t.Error("expected metrics of stopped cache to be deleted")
+	}
+}
+
+// cacheMetrics returns names of the cache metrics reported with the label
+func cacheMetrics(t *testing.T, label string) []string {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, labelPair := range metric.GetLabel() {
+				if labelPair.GetName() == "datasource" && labelPair.GetValue() == label {
+					names = append(names, family.GetName())
+				}
+			}
+		}
+	}
+	return names
+}
+
+func TestLRUCacheStopKeepsMetricsOfReplacement(t *testing.T) {
+	label := fmt.Sprintf("test-replacement-%d", time.Now().UnixNano())
+	old := NewLRUCache(time.Hour, 0, 0, 0, label)
+	old.Set("a", "value", DefaultExpiration)
+	// Instance for the updated settings is created before the old one is disposed
+	replacement := NewLRUCache(time.Hour, 0, 0, 0, label)
+	replacement.Set("a", "value", DefaultExpiration)
+	replacement.Get("a")
+
+	old.Stop()
+	if metrics := cacheMetrics(t, label); !slices.Contains(metrics, "grafana_plugin_strava_cache_entries") ||
+		!slices.Contains(metrics, "grafana_plugin_strava_cache_hits_total") {
+		t.Errorf("expected metrics of the replacement cache, got %v", metrics)
+	}
+
+	replacement.Stop()
+	if metrics := cacheMetrics(t, label); len(metrics) > 0 {
+		t.Errorf("expected metrics to be deleted, got %v", metrics)
+	}
+}
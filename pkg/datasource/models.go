@@ -1,24 +1,50 @@
 package datasource
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
 
 type StravaAPIRequest struct {
-	Endpoint    string                     `json:"endpoint"`
-	Params      map[string]json.RawMessage `json:"params,omitempty"`
+	Endpoint string                     `json:"endpoint"`
+	Params   map[string]json.RawMessage `json:"params,omitempty"`
+	// AthleteId selects athlete whose token is used, default athlete if empty
+	AthleteId   int64 `json:"athleteId,omitempty"`
 	AccessToken string
 }
 
 type StravaApiResourceResponse struct {
 	Result interface{} `json:"result,omitempty"`
+	// Stale is set if response is served from cache after its TTL while it's being refreshed
+	Stale bool `json:"-"`
+}
+
+// Unmarshal decodes API response result into the given value
+func (r *StravaApiResourceResponse) Unmarshal(v interface{}) error {
+	result, err := json.Marshal(r.Result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(result, v)
 }
 
 type StravaAuthRequest struct {
 	AuthCode string `json:"authCode"`
+	// AddAthlete stores tokens for the authorized athlete instead of replacing default athlete
+	AddAthlete bool `json:"addAthlete"`
+}
+
+// CacheInvalidateRequest selects cached responses to remove: responses of the endpoints matching the pattern
+// or responses related to the activity.
+type CacheInvalidateRequest struct {
+	Endpoint   string `json:"endpoint,omitempty"`
+	ActivityId int64  `json:"activityId,omitempty"`
 }
 
 type StravaAuthResourceResponse struct {
@@ -29,6 +55,9 @@ type TokenExchangeResponse struct {
 	AccessToken      string `json:"access_token"`
 	AccessTokenExpAt int64  `json:"expires_at"`
 	RefreshToken     string `json:"refresh_token"`
+	AthleteId        int64  `json:"-"`
+	// StravaAthleteId is a Strava id of the authorized athlete, AthleteId is 0 for the default athlete
+	StravaAthleteId int64 `json:"-"`
 }
 
 // QueryModel model
@@ -39,8 +68,66 @@ type QueryModel struct {
 	Format       string `json:"format"`
 	Interval     string `json:"interval"`
 
+	ExtendedStats []string `json:"extendedStats"`
+
+	// MeasurementPreference overrides athlete's preference (meters or feet)
+	MeasurementPreference string `json:"measurementPreference"`
+
+	// AthleteId selects one of the authorized athletes, default athlete if empty
+	AthleteId int64 `json:"athleteId"`
+
+	// Activity query fields
+	ActivityId         ObjectId `json:"activityId"`
+	ActivityData       string   `json:"activityData"`
+	ActivityGraph      string   `json:"activityGraph"`
+	SplitStat          string   `json:"splitStat"`
+	SingleActivityStat string   `json:"singleActivityStat"`
+	FitToTimeRange     bool     `json:"fitToTimeRange"`
+
+	// Segment effort query fields
+	SegmentEffortId ObjectId `json:"segmentEffortId"`
+	SegmentData     string   `json:"segmentData"`
+	SegmentGraph    string   `json:"segmentGraph"`
+
 	// Direct from the gRPC interfaces
-	TimeRange backend.TimeRange `json:"-"`
+	RefID       string            `json:"-"`
+	TimeRange   backend.TimeRange `json:"-"`
+	AccessToken string            `json:"-"`
+}
+
+// ObjectId is an id of the Strava object selected in the query. Saved queries keep it as a number or a string,
+// which might be empty or contain template variable that isn't interpolated yet, so any value is accepted and
+// validated when query is executed.
+type ObjectId string
+
+func (id *ObjectId) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil:
+		*id = ""
+	case string:
+		*id = ObjectId(strings.TrimSpace(v))
+	case json.Number:
+		*id = ObjectId(v.String())
+	default:
+		return fmt.Errorf("invalid object id: %s", data)
+	}
+	return nil
+}
+
+// Valid returns true if id is a positive integer, as all ids returned by Strava API
+func (id ObjectId) Valid() bool {
+	value, err := strconv.ParseInt(string(id), 10, 64)
+	return err == nil && value > 0
+}
+
+func (id ObjectId) String() string {
+	return string(id)
 }
 
 // ReadQuery will read and validate Settings from the DataSourceConfig
@@ -50,15 +137,267 @@ func ReadQuery(query backend.DataQuery) (QueryModel, error) {
 		return model, fmt.Errorf("could not read query: %w", err)
 	}
 
+	model.RefID = query.RefID
 	model.TimeRange = query.TimeRange
 	return model, nil
 }
 
 type StravaDatasourceSettingsDTO struct {
-	ClientID string `json:"clientID"`
-	CacheTTL string `json:"cacheTTL"`
+	ClientID              string `json:"clientID"`
+	CacheTTL              string `json:"cacheTTL"`
+	MeasurementPreference string `json:"measurementPreference"`
+	// TokenStore defines where refresh token is kept: grafana, file or memory
+	TokenStore string `json:"tokenStore"`
+	// DiskCacheSizeMB limits size of the persistent cache, default size is used if not set, negative value
+	// disables persistent cache
+	DiskCacheSizeMB int `json:"diskCacheSizeMB"`
+	// CacheMaxEntries and CacheMaxSizeMB limit in-memory cache of the data source
+	CacheMaxEntries int `json:"cacheMaxEntries"`
+	CacheMaxSizeMB  int `json:"cacheMaxSizeMB"`
+	// CachePolicies override default caching rules for the matching endpoints
+	CachePolicies []CachePolicySettings `json:"cachePolicies"`
+	// SyncDepth is a number of the latest activities kept in cache by background sync, negative value
+	// disables sync
+	SyncDepth int `json:"syncDepth"`
+	// SyncInterval defines how often new activities are fetched
+	SyncInterval string `json:"syncInterval"`
+	// SyncStreams are activity streams prefetched along with activities
+	SyncStreams []string `json:"syncStreams"`
+	// ActivityStore enables local store of the athlete activities in the data dir, it's synced by background
+	// sync and used to answer Activities queries
+	ActivityStore bool `json:"activityStore"`
+}
+
+// AthleteDTO describes athlete authorized in the data source. Id is used to select athlete in the queries,
+// it's empty for the default athlete.
+type AthleteDTO struct {
+	Id        int64  `json:"id"`
+	AthleteId int64  `json:"athleteId"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
 }
 
 type ActivityDTO = struct {
 	Id int64 `json:"id"`
 }
+
+const (
+	QueryTypeActivities    = "Activities"
+	QueryTypeActivity      = "Activity"
+	QueryTypeSegmentEffort = "SegmentEffort"
+)
+
+const (
+	FormatTimeSeries = "time_series"
+	FormatTable      = "table"
+	FormatWorldMap   = "worldmap"
+	FormatHeatmap    = "heatmap"
+)
+
+const (
+	IntervalNo    = "no"
+	IntervalAuto  = "auto"
+	IntervalHour  = "hour"
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+const (
+	ActivityDataGraph    = "graph"
+	ActivityDataSplits   = "splits"
+	ActivityDataStats    = "stats"
+	ActivityDataGeomap   = "geomap"
+	ActivityDataSegments = "segments"
+)
+
+const (
+	StreamTime        = "time"
+	StreamHeartRate   = "heartrate"
+	StreamAltitude    = "altitude"
+	StreamVelocity    = "velocity_smooth"
+	StreamPace        = "pace"
+	StreamWatts       = "watts"
+	StreamWattsCalc   = "watts_calc"
+	StreamGradeSmooth = "grade_smooth"
+	StreamLatLng      = "latlng"
+)
+
+const (
+	SplitStatPace  = "pace"
+	SplitStatSpeed = "average_speed"
+)
+
+const TopAchievementStat = "top_achievement"
+
+const (
+	ActivityStatDistance      = "distance"
+	ActivityStatMovingTime    = "moving_time"
+	ActivityStatElapsedTime   = "elapsed_time"
+	ActivityStatElevationGain = "total_elevation_gain"
+	ActivityStatAveragePower  = "average_watts"
+)
+
+type StravaAthlete struct {
+	Id                    int64  `json:"id"`
+	FirstName             string `json:"firstname"`
+	LastName              string `json:"lastname"`
+	MeasurementPreference string `json:"measurement_preference"`
+}
+
+type PolylineMap struct {
+	Id              string `json:"id"`
+	Polyline        string `json:"polyline"`
+	SummaryPolyline string `json:"summary_polyline"`
+}
+
+// StravaActivity contains activity fields used by the backend queries. All fields returned by
+// Strava API are also kept in the raw form, so any stat could be read by its name.
+type StravaActivity struct {
+	Id                 int64       `json:"id"`
+	Name               string      `json:"name"`
+	SportType          string      `json:"sport_type"`
+	StartDate          time.Time   `json:"start_date"`
+	Distance           float64     `json:"distance"`
+	MovingTime         int64       `json:"moving_time"`
+	ElapsedTime        int64       `json:"elapsed_time"`
+	TotalElevationGain float64     `json:"total_elevation_gain"`
+	StartLatLng        []float64   `json:"start_latlng"`
+	Map                PolylineMap `json:"map"`
+
+	// Detailed activity fields
+	SplitsMetric   []StravaSplit   `json:"splits_metric"`
+	SplitsStandard []StravaSplit   `json:"splits_standard"`
+	SegmentEfforts []SegmentEffort `json:"segment_efforts"`
+
+	raw map[string]json.RawMessage
+}
+
+func (a *StravaActivity) UnmarshalJSON(data []byte) error {
+	type activityAlias StravaActivity
+	alias := activityAlias{}
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &alias.raw); err != nil {
+		return err
+	}
+	*a = StravaActivity(alias)
+	return nil
+}
+
+// Field returns raw JSON value of the activity field
+func (a *StravaActivity) Field(name string) (json.RawMessage, bool) {
+	value, ok := a.raw[name]
+	return value, ok
+}
+
+// Stat returns numeric value of the activity field or nil if field is missing or not a number
+func (a *StravaActivity) Stat(name string) *float64 {
+	value, ok := a.raw[name]
+	if !ok {
+		return nil
+	}
+	var stat float64
+	if err := json.Unmarshal(value, &stat); err != nil {
+		return nil
+	}
+	return &stat
+}
+
+// StravaSplit contains split stats, like distance, moving_time or average_speed
+type StravaSplit map[string]interface{}
+
+// Stat returns numeric value of the split stat or nil if stat is missing
+func (s StravaSplit) Stat(name string) *float64 {
+	value, ok := s[name].(float64)
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+type SegmentEffort struct {
+	Id               int64         `json:"id"`
+	Name             string        `json:"name"`
+	Achievements     []Achievement `json:"achievements"`
+	AverageHeartrate *float64      `json:"average_heartrate"`
+	AverageWatts     *float64      `json:"average_watts"`
+	Distance         float64       `json:"distance"`
+	ElapsedTime      int64         `json:"elapsed_time"`
+	MovingTime       int64         `json:"moving_time"`
+	StartIndex       int           `json:"start_index"`
+	EndIndex         int           `json:"end_index"`
+	PrRank           *int64        `json:"pr_rank"`
+	StartDate        time.Time     `json:"start_date"`
+	Segment          Segment       `json:"segment"`
+}
+
+type Achievement struct {
+	Rank int64  `json:"rank"`
+	Type string `json:"type"`
+}
+
+type Segment struct {
+	Id                  int64                `json:"id"`
+	ActivityType        string               `json:"activity_type"`
+	AverageGrade        float64              `json:"average_grade"`
+	ElevationHigh       float64              `json:"elevation_high"`
+	ElevationLow        float64              `json:"elevation_low"`
+	AthleteSegmentStats *AthleteSegmentStats `json:"athlete_segment_stats"`
+	Xoms                *SegmentXoms         `json:"xoms"`
+}
+
+type AthleteSegmentStats struct {
+	PrElapsedTime *int64 `json:"pr_elapsed_time"`
+}
+
+type SegmentXoms struct {
+	Overall string `json:"overall"`
+}
+
+// StravaStream is an activity data stream. Data contains numbers for the most of streams,
+// [lat, lng] pairs for the latlng stream and booleans for the moving stream.
+type StravaStream struct {
+	OriginalSize int             `json:"original_size"`
+	Resolution   string          `json:"resolution"`
+	SeriesType   string          `json:"series_type"`
+	Data         json.RawMessage `json:"data"`
+}
+
+// StravaStreamSet is a set of activity streams keyed by stream type
+type StravaStreamSet map[string]StravaStream
+
+// Values returns stream data as numbers, non-numeric points are returned as nulls
+func (s StravaStream) Values() []*float64 {
+	rawValues := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(s.Data, &rawValues); err != nil {
+		return []*float64{}
+	}
+	values := make([]*float64, len(rawValues))
+	for i, rawValue := range rawValues {
+		var value float64
+		if err := json.Unmarshal(rawValue, &value); err == nil {
+			values[i] = &value
+		}
+	}
+	return values
+}
+
+// TimeTicks returns time stream data as seconds from the activity start
+func (s StravaStream) TimeTicks() []int64 {
+	ticks := make([]int64, 0)
+	if err := json.Unmarshal(s.Data, &ticks); err != nil {
+		return []int64{}
+	}
+	return ticks
+}
+
+// LatLng returns latlng stream data
+func (s StravaStream) LatLng() [][2]float64 {
+	points := make([][2]float64, 0)
+	if err := json.Unmarshal(s.Data, &points); err != nil {
+		return [][2]float64{}
+	}
+	return points
+}
@@ -0,0 +1,71 @@
+package datasource
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestReadQueryActivityId(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		id    string
+		valid bool
+	}{
+		{name: "number", json: `{"activityId": 1234567890123}`, id: "1234567890123", valid: true},
+		{name: "string", json: `{"activityId": "1234567890123"}`, id: "1234567890123", valid: true},
+		{name: "empty string", json: `{"activityId": ""}`, id: "", valid: false},
+		{name: "missing", json: `{}`, id: "", valid: false},
+		{name: "null", json: `{"activityId": null}`, id: "", valid: false},
+		{name: "template variable", json: `{"activityId": "$activity"}`, id: "$activity", valid: false},
+		{name: "negative", json: `{"activityId": -1}`, id: "-1", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ReadQuery(backend.DataQuery{JSON: []byte(tt.json)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if query.ActivityId.String() != tt.id {
+				t.Errorf("expected id %q, got %q", tt.id, query.ActivityId)
+			}
+			if query.ActivityId.Valid() != tt.valid {
+				t.Errorf("expected valid %v, got %v", tt.valid, query.ActivityId.Valid())
+			}
+		})
+	}
+}
+
+func TestReadQueryInvalidActivityId(t *testing.T) {
+	_, err := ReadQuery(backend.DataQuery{JSON: []byte(`{"activityId": {"id": 1}}`)})
+	if err == nil {
+		t.Fatal("expected error for object id")
+	}
+}
+
+func TestReadQuerySegmentEffortId(t *testing.T) {
+	tests := []struct {
+		name  string
+		json  string
+		valid bool
+	}{
+		{name: "number", json: `{"activityId": 1, "segmentEffortId": 2345678901234}`, valid: true},
+		{name: "string", json: `{"activityId": "1", "segmentEffortId": "2345678901234"}`, valid: true},
+		{name: "empty string", json: `{"activityId": 1, "segmentEffortId": ""}`, valid: false},
+		{name: "template variable", json: `{"activityId": 1, "segmentEffortId": "${effort}"}`, valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := ReadQuery(backend.DataQuery{JSON: []byte(tt.json)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if query.SegmentEffortId.Valid() != tt.valid {
+				t.Errorf("expected valid %v, got %v", tt.valid, query.SegmentEffortId.Valid())
+			}
+		})
+	}
+}
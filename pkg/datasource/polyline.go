@@ -0,0 +1,54 @@
+package datasource
+
+// This file contains code ported from google-polyline
+// https://github.com/jhermsmeier/node-google-polyline/blob/master/lib/decode.js
+
+const polylinePrecision = 1e5
+
+// decodePolyline decodes encoded polyline string into the list of [lat, lng] points
+func decodePolyline(value string) [][2]float64 {
+	points := make([][2]float64, 0)
+	lat, lng := 0, 0
+
+	polylineIntegers(value, func(x int, y int) {
+		lat += x
+		lng += y
+		points = append(points, [2]float64{float64(lat) / polylinePrecision, float64(lng) / polylinePrecision})
+	})
+
+	return points
+}
+
+func polylineSign(value int) int {
+	if value&1 != 0 {
+		return ^(value >> 1)
+	}
+	return value >> 1
+}
+
+func polylineIntegers(value string, callback func(x int, y int)) int {
+	values := 0
+	x, y := 0, 0
+	current := 0
+	bits := 0
+
+	for i := 0; i < len(value); i++ {
+		b := int(value[i]) - 63
+		current = current | ((b & 0x1f) << bits)
+		bits = bits + 5
+
+		if b < 0x20 {
+			values++
+			if values&1 != 0 {
+				x = polylineSign(current)
+			} else {
+				y = polylineSign(current)
+				callback(x, y)
+			}
+			current = 0
+			bits = 0
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,38 @@
+package datasource
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecodePolyline(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected [][2]float64
+	}{
+		{name: "empty", value: "", expected: [][2]float64{}},
+		{
+			// Example from the polyline algorithm documentation
+			name:     "points",
+			value:    "_p~iF~ps|U_ulLnnqC_mqNvxq`@",
+			expected: [][2]float64{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}},
+		},
+		{name: "single point", value: "??", expected: [][2]float64{{0, 0}}},
+		{name: "incomplete point", value: "_p~iF", expected: [][2]float64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := decodePolyline(tt.value)
+			if len(points) != len(tt.expected) {
+				t.Fatalf("expected %d points, got %v", len(tt.expected), points)
+			}
+			for i, point := range points {
+				if math.Abs(point[0]-tt.expected[i][0]) > 1e-9 || math.Abs(point[1]-tt.expected[i][1]) > 1e-9 {
+					t.Errorf("point %d: expected %v, got %v", i, tt.expected[i], point)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package datasource
+
+import "time"
+
+// States of the prefetch task
+const (
+	PrefetchTaskQueued  = "queued"
+	PrefetchTaskRunning = "running"
+	PrefetchTaskDone    = "done"
+	PrefetchTaskFailed  = "failed"
+)
+
+// PrefetchTaskStatus describes prefetching of the single activity
+type PrefetchTaskStatus struct {
+	ActivityId int64  `json:"activityId"`
+	State      string `json:"state"`
+	Error      string `json:"error,omitempty"`
+}
+
+// PrefetchStatus describes the current or last completed batch of prefetched activities. Times are unix
+// seconds.
+type PrefetchStatus struct {
+	Running    bool                 `json:"running"`
+	Total      int                  `json:"total"`
+	Completed  int                  `json:"completed"`
+	Failed     int                  `json:"failed"`
+	StartedAt  int64                `json:"startedAt,omitempty"`
+	FinishedAt int64                `json:"finishedAt,omitempty"`
+	Tasks      []PrefetchTaskStatus `json:"tasks"`
+}
+
+// SyncStatus is returned by the prefetch status endpoint
+type SyncStatus struct {
+	Enabled  bool            `json:"enabled"`
+	Sync     *SyncState      `json:"sync,omitempty"`
+	Prefetch *PrefetchStatus `json:"prefetch,omitempty"`
+	// ActivityStore is set if local activity store is enabled
+	ActivityStore *ActivityStoreState `json:"activityStore,omitempty"`
+}
+
+// Status returns sync progress and status of the prefetch tasks
+func (p *StravaPrefetcher) Status() SyncStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.state
+	prefetch := p.status
+	prefetch.Tasks = append([]PrefetchTaskStatus{}, p.status.Tasks...)
+	return SyncStatus{Enabled: true, Sync: &state, Prefetch: &prefetch}
+}
+
+func (p *StravaPrefetcher) startPrefetch(activityIds []int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tasks := make([]PrefetchTaskStatus, 0, len(activityIds))
+	for _, id := range activityIds {
+		tasks = append(tasks, PrefetchTaskStatus{ActivityId: id, State: PrefetchTaskQueued})
+	}
+	p.status = PrefetchStatus{
+		Running:   true,
+		Total:     len(activityIds),
+		StartedAt: time.Now().Unix(),
+		Tasks:     tasks,
+	}
+}
+
+func (p *StravaPrefetcher) updateTask(i int, state string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	task := &p.status.Tasks[i]
+	task.State = state
+	switch state {
+	case PrefetchTaskDone:
+		p.status.Completed++
+	case PrefetchTaskFailed:
+		p.status.Failed++
+		task.Error = err.Error()
+	}
+}
+
+func (p *StravaPrefetcher) finishPrefetch() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status.Running = false
+	p.status.FinishedAt = time.Now().Unix()
+}
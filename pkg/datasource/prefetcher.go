@@ -3,157 +3,633 @@ package datasource
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
 const MaxTasks = 4
 
+// Default sync settings, used if not set in the data source settings
+const (
+	DefaultSyncDepth    = 50
+	DefaultSyncInterval = 15 * time.Minute
+)
+
+// SyncBatchSize is a max number of activities fetched with a single list request. Backfill syncs one batch
+// per interval, so history is loaded gradually without exhausting rate limits.
+const SyncBatchSize = 10
+
+// DefaultSyncStreams are activity streams prefetched along with the activity details
+var DefaultSyncStreams = []string{"velocity_smooth", "heartrate", "latlng"}
+
+// SyncState is a progress of the background sync. It's saved in the data dir, so sync continues from the
+// last seen activity after restart. Times are unix seconds.
+type SyncState struct {
+	// AthleteId is a Strava id of the athlete whose activities are synced
+	AthleteId int64 `json:"athleteId,omitempty"`
+	// LastActivityId and LastActivityTime describe the newest synced activity
+	LastActivityId   int64 `json:"lastActivityId"`
+	LastActivityTime int64 `json:"lastActivityTime"`
+	// OldestActivityTime is a start time of the oldest synced activity, backfill continues before it
+	OldestActivityTime int64  `json:"oldestActivityTime"`
+	SyncedActivities   int    `json:"syncedActivities"`
+	BackfillComplete   bool   `json:"backfillComplete"`
+	LastSyncAt         int64  `json:"lastSyncAt"`
+	LastError          string `json:"lastError,omitempty"`
+	// SkippedActivities contains activities which can't be loaded, like deleted or private ones
+	SkippedActivities []int64 `json:"skippedActivities,omitempty"`
+}
+
+// MaxSkippedActivities limits number of skipped activities kept in the sync state
+const MaxSkippedActivities = 100
+
+// StravaPrefetcher keeps recent activities of the default athlete in cache. It periodically fetches activities
+// newer than the last seen one and backfills older activities until sync depth is reached.
 type StravaPrefetcher struct {
-	depth      int
-	cache      *DSCache
-	ds         *StravaDatasourceInstance
-	activities []string
+	depth    int
+	interval time.Duration
+	streams  []string
+	cache    *DSCache
+	ds       *StravaDatasourceInstance
+	// ctx marks prefetcher requests as background ones for the rate limiter, it's cancelled when data source
+	// instance is disposed
+	ctx context.Context
+
+	stateFile string
+	mu        sync.Mutex
+	state     SyncState
+	status    PrefetchStatus
+	// syncMu serializes sync runs and resets of the synced data
+	syncMu sync.Mutex
 }
 
-func NewStravaPrefetcher(depth int, ds *StravaDatasourceInstance) *StravaPrefetcher {
-	return &StravaPrefetcher{
-		depth:      depth,
-		cache:      ds.cache,
-		ds:         ds,
-		activities: []string{},
+// NewStravaPrefetcher creates prefetcher configured by the data source sync settings. Sync progress is kept in
+// the data dir if it's set.
+func NewStravaPrefetcher(ds *StravaDatasourceInstance, settings *StravaDatasourceSettingsDTO, dataDir string) *StravaPrefetcher {
+	p := &StravaPrefetcher{
+		depth:    settings.SyncDepth,
+		interval: DefaultSyncInterval,
+		streams:  settings.SyncStreams,
+		cache:    ds.cache,
+		ds:       ds,
+		ctx:      WithBackgroundPriority(ds.ctx),
+	}
+	if p.depth == 0 {
+		p.depth = DefaultSyncDepth
+	}
+	if len(p.streams) == 0 {
+		p.streams = DefaultSyncStreams
+	}
+	if settings.SyncInterval != "" {
+		interval, err := gtime.ParseInterval(settings.SyncInterval)
+		if err != nil || interval <= 0 {
+			log.DefaultLogger.Warn("Cannot read sync interval, using default one", "interval", settings.SyncInterval, "error", err)
+		} else {
+			p.interval = interval
+		}
+	}
+
+	if dataDir != "" {
+		p.stateFile = filepath.Join(dataDir, fmt.Sprintf("%d-sync-state.json", ds.dsInfo.ID))
+		p.loadState()
 	}
+	return p
 }
 
-// Run starts background prefetcher task
+// Run starts background sync, it runs until data source instance is disposed
 func (p *StravaPrefetcher) Run() {
-	log.DefaultLogger.Info("Starting background prefetcher")
+	log.DefaultLogger.Info("Starting background sync", "depth", p.depth, "interval", p.interval)
 
-	activities, err := p.GetActivities()
-	if err != nil {
-		log.DefaultLogger.Error("Error fetching activities", "error", err)
+	p.PrefetchActivitiesVariable(10)
+	p.PrefetchActivitiesVariable(100)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.Sync()
+		select {
+		case <-p.ctx.Done():
+			log.DefaultLogger.Debug("Background sync stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync fetches activities created since the last sync and then backfills one batch of older activities
+func (p *StravaPrefetcher) Sync() {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	newActivities := 0
+	err := p.checkAthlete()
+	if err == nil {
+		newActivities, err = p.syncNewActivities()
+	}
+	if err == nil && newActivities > 0 {
+		// Lists of activities are outdated, refresh the ones used by variables
+		p.ds.InvalidateCache(regexp.MustCompile(`^athlete/activities$`))
+		p.PrefetchActivitiesVariable(10)
+		p.PrefetchActivitiesVariable(100)
+	}
+	if err == nil {
+		err = p.backfill()
+	}
+	if err == nil && p.ds.activityStore != nil {
+		err = p.syncActivityStore()
+	}
+	if p.ctx.Err() != nil {
+		// Instance is disposed, keep the state saved by the last completed sync
 		return
 	}
 
-	p.PrefetchActivitiesVariable(10)
-	p.PrefetchActivitiesVariable(100)
-	p.PrefetchActivities(activities)
+	p.mu.Lock()
+	p.state.LastSyncAt = time.Now().Unix()
+	p.state.LastError = ""
+	if err != nil {
+		p.state.LastError = err.Error()
+	}
+	p.mu.Unlock()
+	p.saveState()
+
+	switch {
+	case err == nil:
+		log.DefaultLogger.Debug("Background sync completed", "new activities", newActivities)
+	case errors.Is(err, ErrRateLimitExceeded):
+		log.DefaultLogger.Debug("Background sync postponed, rate limit budget is exhausted", "error", err)
+	case errors.Is(err, context.Canceled):
+	default:
+		log.DefaultLogger.Error("Background sync error", "error", err)
+	}
+}
+
+// SetAthlete sets athlete whose activities are synced. If another athlete's activities were synced, sync
+// progress and activity store are reset. It returns true if athlete is changed.
+func (p *StravaPrefetcher) SetAthlete(athleteId int64) (bool, error) {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+	return p.setAthlete(athleteId)
+}
+
+// Reset removes sync progress and stored activities, so history is synced from scratch
+func (p *StravaPrefetcher) Reset() error {
+	p.syncMu.Lock()
+	defer p.syncMu.Unlock()
+
+	p.mu.Lock()
+	p.state = SyncState{}
+	p.mu.Unlock()
+	p.saveState()
+	if p.ds.activityStore != nil {
+		return p.ds.activityStore.Clear()
+	}
+	return nil
+}
+
+// checkAthlete resets synced data if default athlete was authorized with another Strava account
+func (p *StravaPrefetcher) checkAthlete() error {
+	athlete, err := p.ds.GetAthlete(p.ctx, DefaultAthleteId, "")
+	if err != nil {
+		return err
+	}
+	_, err = p.setAthlete(athlete.Id)
+	return err
+}
+
+// setAthlete updates owner of the sync state and activity store, caller should hold syncMu. Data synced before
+// the owner was recorded is kept.
+func (p *StravaPrefetcher) setAthlete(athleteId int64) (bool, error) {
+	changed := false
+	p.mu.Lock()
+	if p.state.AthleteId != athleteId {
+		if p.state.AthleteId != 0 {
+			log.DefaultLogger.Info("Athlete changed, sync state reset", "previous", p.state.AthleteId, "athlete", athleteId)
+			p.state = SyncState{}
+			changed = true
+		}
+		p.state.AthleteId = athleteId
+	}
+	p.mu.Unlock()
+	p.saveState()
+
+	store := p.ds.activityStore
+	if store == nil {
+		return changed, nil
+	}
+	state, err := store.State()
+	if err != nil {
+		return changed, fmt.Errorf("error reading activity store: %w", err)
+	}
+	if state.AthleteId == athleteId {
+		return changed, nil
+	}
+	if state.AthleteId != 0 {
+		log.DefaultLogger.Info("Athlete changed, activity store cleared", "previous", state.AthleteId, "athlete", athleteId)
+		if err := store.Clear(); err != nil {
+			return changed, fmt.Errorf("error clearing activity store: %w", err)
+		}
+		state = ActivityStoreState{}
+		changed = true
+	}
+	state.AthleteId = athleteId
+	return changed, store.SetState(state)
+}
+
+// State returns current sync progress
+func (p *StravaPrefetcher) State() SyncState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// syncNewActivities prefetches activities started after the last seen one and returns number of synced
+// activities. On the first run it syncs the latest batch.
+func (p *StravaPrefetcher) syncNewActivities() (int, error) {
+	state := p.State()
+	if state.LastActivityId == 0 {
+		activities, err := p.listActivities(map[string]string{"per_page": fmt.Sprint(min(SyncBatchSize, p.depth))})
+		if err != nil {
+			return 0, err
+		}
+		return p.syncOlderActivities(activities, min(SyncBatchSize, p.depth))
+	}
+
+	synced := 0
+	for page := 1; ; page++ {
+		// Activities listed with "after" param are ordered from the oldest to the newest one
+		activities, err := p.listActivities(map[string]string{
+			"after":    fmt.Sprint(state.LastActivityTime),
+			"per_page": fmt.Sprint(SyncBatchSize),
+			"page":     fmt.Sprint(page),
+		})
+		if err != nil {
+			return synced, err
+		}
+		if len(activities) == 0 {
+			return synced, nil
+		}
+
+		newActivities := make([]StravaActivity, 0, len(activities))
+		for _, activity := range activities {
+			if activity.Id != state.LastActivityId && activity.StartDate.Unix() >= state.LastActivityTime {
+				newActivities = append(newActivities, activity)
+			}
+		}
+
+		errs := p.PrefetchActivities(activityIds(newActivities))
+		loaded := make([]StravaActivity, 0, len(newActivities))
+		for i, activity := range newActivities {
+			// Move sync position only up to the first activity failed with temporary error, so it's retried
+			// on the next sync
+			if errs[i] != nil && !isPermanentError(errs[i]) {
+				p.ds.activityFeed.Publish(loaded)
+				return synced, errs[i]
+			}
+			p.mu.Lock()
+			p.state.LastActivityId = activity.Id
+			p.state.LastActivityTime = activity.StartDate.Unix()
+			p.mu.Unlock()
+			if errs[i] != nil {
+				p.skipActivity(activity.Id, errs[i])
+				continue
+			}
+			p.mu.Lock()
+			p.state.SyncedActivities++
+			p.mu.Unlock()
+			loaded = append(loaded, activity)
+			synced++
+		}
+		p.ds.activityFeed.Publish(loaded)
+	}
+}
+
+// backfill prefetches one batch of activities older than already synced ones until sync depth is reached
+func (p *StravaPrefetcher) backfill() error {
+	state := p.State()
+	if state.BackfillComplete || state.SyncedActivities >= p.depth {
+		return nil
+	}
+
+	batchSize := min(SyncBatchSize, p.depth-state.SyncedActivities)
+	activities, err := p.listActivities(map[string]string{
+		"before":   fmt.Sprint(state.OldestActivityTime),
+		"per_page": fmt.Sprint(batchSize),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = p.syncOlderActivities(activities, batchSize)
+	return err
+}
+
+// syncOlderActivities prefetches activities ordered from the newest to the oldest one and moves backfill
+// position. History is complete when Strava returns less activities than requested.
+func (p *StravaPrefetcher) syncOlderActivities(activities []StravaActivity, batchSize int) (int, error) {
+	synced := 0
+	errs := p.PrefetchActivities(activityIds(activities))
+	for i, activity := range activities {
+		if errs[i] != nil && !isPermanentError(errs[i]) {
+			return synced, errs[i]
+		}
+		p.mu.Lock()
+		if p.state.LastActivityId == 0 {
+			p.state.LastActivityId = activity.Id
+			p.state.LastActivityTime = activity.StartDate.Unix()
+		}
+		p.state.OldestActivityTime = activity.StartDate.Unix()
+		if errs[i] == nil {
+			p.state.SyncedActivities++
+		}
+		p.mu.Unlock()
+		if errs[i] != nil {
+			p.skipActivity(activity.Id, errs[i])
+			continue
+		}
+		synced++
+	}
+
+	if len(activities) < batchSize {
+		p.mu.Lock()
+		p.state.BackfillComplete = true
+		p.mu.Unlock()
+	}
+	return synced, nil
+}
+
+// syncActivityStore saves activities created since the last sync to the activity store, then loads a few pages
+// of older activities and details of the latest activities missing in the store
+func (p *StravaPrefetcher) syncActivityStore() error {
+	store := p.ds.activityStore
+	state, err := store.State()
+	if err != nil {
+		return fmt.Errorf("error reading activity store: %w", err)
+	}
+	defer func() {
+		state.LastSyncAt = time.Now().Unix()
+		if err := store.SetState(state); err != nil {
+			log.DefaultLogger.Warn("Cannot save activity store state", "error", err)
+		}
+	}()
+
+	if state.NewestActivityTime > 0 {
+		// Pages are requested with the same "after" param, so position is moved only when all pages are stored
+		after := state.NewestActivityTime
+		newest := after
+		for page := 1; ; page++ {
+			// Activities listed with "after" param are ordered from the oldest to the newest one
+			activities, err := p.storeActivities(map[string]string{
+				"after":    fmt.Sprint(after),
+				"per_page": fmt.Sprint(ActivityStorePageSize),
+				"page":     fmt.Sprint(page),
+			})
+			if err != nil {
+				return err
+			}
+			if len(activities) > 0 {
+				newest = max(newest, activities[len(activities)-1].StartDate.Unix())
+			}
+			if len(activities) < ActivityStorePageSize {
+				break
+			}
+		}
+		state.NewestActivityTime = newest
+	}
+
+	for page := 0; page < ActivityStoreBackfillPages && !state.HistoryComplete; page++ {
+		params := map[string]string{"per_page": fmt.Sprint(ActivityStorePageSize)}
+		if state.OldestActivityTime > 0 {
+			params["before"] = fmt.Sprint(state.OldestActivityTime)
+		}
+		activities, err := p.storeActivities(params)
+		if err != nil {
+			return err
+		}
+		if len(activities) > 0 {
+			if state.NewestActivityTime == 0 {
+				state.NewestActivityTime = activities[0].StartDate.Unix()
+			}
+			state.OldestActivityTime = activities[len(activities)-1].StartDate.Unix()
+		}
+		state.HistoryComplete = len(activities) < ActivityStorePageSize
+	}
+
+	missing, err := store.MissingDetails(SyncBatchSize)
+	if err != nil {
+		return fmt.Errorf("error reading activity store: %w", err)
+	}
+	for _, activityId := range missing {
+		_, err := p.ds.GetActivity(p.ctx, fmt.Sprint(activityId), DefaultAthleteId, "")
+		if err != nil && !isPermanentError(err) {
+			return err
+		}
+		if err != nil {
+			p.skipActivity(activityId, err)
+			// Deleted activity is removed from the store, so it isn't requested again
+			if errorStatusCode(err) == http.StatusNotFound {
+				if err := store.DeleteActivity(activityId); err != nil {
+					return fmt.Errorf("error deleting activity from store: %w", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// skipActivity records activity which can't be loaded, so sync moves on instead of retrying it forever
+func (p *StravaPrefetcher) skipActivity(activityId int64, err error) {
+	log.DefaultLogger.Warn("Skipping activity which can't be loaded", "activityId", activityId, "error", err)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state.SkippedActivities = append(p.state.SkippedActivities, activityId)
+	if len(p.state.SkippedActivities) > MaxSkippedActivities {
+		p.state.SkippedActivities = p.state.SkippedActivities[len(p.state.SkippedActivities)-MaxSkippedActivities:]
+	}
+}
+
+// isPermanentError returns true if request of the activity fails with client error which won't go away on
+// retry, like deleted (404) or private (403) activity. Exhausted rate limit and authorization problems affect
+// all activities, so they aren't permanent for the activity.
+func isPermanentError(err error) bool {
+	var apiErr *StravaAPIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.StatusCode < 400 || apiErr.StatusCode >= 500 {
+		return false
+	}
+	if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == http.StatusUnauthorized {
+		return false
+	}
+	return apiErr.Unwrap() == nil
 }
 
-func (p *StravaPrefetcher) GetActivities() ([]string, error) {
+// storeActivities requests list of activities and saves them to the activity store
+func (p *StravaPrefetcher) storeActivities(params map[string]string) ([]StravaActivity, error) {
 	query := &StravaAPIRequest{
 		Endpoint: "athlete/activities",
-		Params: map[string]json.RawMessage{
-			"per_page": []byte(fmt.Sprintf("%d", p.depth)),
-		},
+		Params:   make(map[string]json.RawMessage),
 	}
-	resp, err := p.ds.StravaAPIQuery(context.Background(), query)
+	for param, value := range params {
+		query.Params[param] = []byte(value)
+	}
+	resp, err := p.ds.StravaAPIQuery(p.ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching activities: %w", err)
 	}
-	activitiesRaw, ok := resp.Result.([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("error parsing activities")
+
+	raw := make([]json.RawMessage, 0)
+	err = resp.Unmarshal(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing activities: %w", err)
+	}
+	err = p.ds.activityStore.PutActivities(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error saving activities: %w", err)
 	}
-	activities := make([]string, 0)
-	for _, a := range activitiesRaw {
-		activity, ok := a.(map[string]interface{})
-		if !ok {
-			return nil, fmt.Errorf("error parsing activity")
-		}
-		activityIdRaw := activity["id"]
-		activityId := activityIdRaw.(json.Number).String()
-		activities = append(activities, activityId)
+
+	activities := make([]StravaActivity, 0)
+	err = resp.Unmarshal(&activities)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing activities: %w", err)
+	}
+	return activities, nil
+}
+
+// listActivities requests list of activities bypassing cache, so new activities are always visible
+func (p *StravaPrefetcher) listActivities(params map[string]string) ([]StravaActivity, error) {
+	query := &StravaAPIRequest{
+		Endpoint: "athlete/activities",
+		Params:   make(map[string]json.RawMessage),
+	}
+	for param, value := range params {
+		query.Params[param] = []byte(value)
+	}
+	resp, err := p.ds.StravaAPIQuery(p.ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching activities: %w", err)
+	}
+
+	activities := make([]StravaActivity, 0)
+	err = resp.Unmarshal(&activities)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing activities: %w", err)
 	}
 	return activities, nil
 }
 
-func (p *StravaPrefetcher) PrefetchActivities(activities []string) {
-	log.DefaultLogger.Debug("Prefetching activities", "activities", len(activities))
-	queue := make(chan int, MaxTasks)
-	for i := 0; i < p.depth; i++ {
-		activityId := activities[i]
-		queue <- 1
-		go func(activityId string) {
-			p.PrefetchActivity(activityId)
-			p.PrefetchActivityStreams(activityId)
-			<-queue
-		}(activityId)
+func activityIds(activities []StravaActivity) []int64 {
+	ids := make([]int64, 0, len(activities))
+	for _, activity := range activities {
+		ids = append(ids, activity.Id)
 	}
+	return ids
 }
 
-func (p *StravaPrefetcher) PrefetchActivity(activityId string) {
-	payloadPattern := `{"datasourceId":%d,"endpoint":"/activities/%s","params":{"include_all_efforts":true}}`
-	payload := fmt.Sprintf(payloadPattern, p.ds.dsInfo.ID, activityId)
+func (p *StravaPrefetcher) prefetchActivity(activityId int64) error {
+	err := p.PrefetchActivity(fmt.Sprint(activityId))
+	if err != nil {
+		return err
+	}
+	return p.PrefetchActivityStreams(fmt.Sprint(activityId))
+}
 
-	requestHash := HashString(payload)
-	stravaApiQueryFn := p.ds.StravaAPIQueryWithCache(requestHash)
+// PrefetchActivities loads details and streams of the activities using MaxTasks workers and waits until all
+// tasks are completed. Returned errors correspond to the activities, nil means activity is loaded. Progress of
+// the tasks is available in the prefetch status.
+func (p *StravaPrefetcher) PrefetchActivities(activityIds []int64) []error {
+	errs := make([]error, len(activityIds))
+	if len(activityIds) == 0 {
+		return errs
+	}
+	log.DefaultLogger.Debug("Prefetching activities", "activities", len(activityIds))
+	p.startPrefetch(activityIds)
+
+	tasks := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(MaxTasks, len(activityIds)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				errs[i] = p.runPrefetchTask(i, activityIds[i])
+			}
+		}()
+	}
+	for i := range activityIds {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+
+	p.finishPrefetch()
+	return errs
+}
+
+// runPrefetchTask prefetches single activity and records result in the prefetch status. Panic is reported
+// as a task error, so it doesn't stop other tasks or crash the plugin.
+func (p *StravaPrefetcher) runPrefetchTask(i int, activityId int64) (err error) {
+	p.updateTask(i, PrefetchTaskRunning, nil)
+	defer func() {
+		if r := recover(); r != nil {
+			log.DefaultLogger.Error("Prefetch task panic", "activityId", activityId, "panic", r, "stack", string(debug.Stack()))
+			err = fmt.Errorf("prefetch of activity %d failed: %v", activityId, r)
+		}
+		if err != nil {
+			p.updateTask(i, PrefetchTaskFailed, err)
+		} else {
+			p.updateTask(i, PrefetchTaskDone, nil)
+		}
+	}()
+
+	return p.prefetchActivity(activityId)
+}
+
+func (p *StravaPrefetcher) PrefetchActivity(activityId string) error {
 	apiReq := &StravaAPIRequest{
 		Endpoint: fmt.Sprintf("/activities/%s", activityId),
 		Params: map[string]json.RawMessage{
 			"include_all_efforts": []byte("true"),
 		},
 	}
-	_, err := stravaApiQueryFn(context.Background(), apiReq)
+	_, err := p.ds.StravaAPIQueryWithCache(p.ctx, apiReq)
 	if err != nil {
-		log.DefaultLogger.Error("Error loading activity", "error", err)
+		return fmt.Errorf("error loading activity %s: %w", activityId, err)
 	}
+	return nil
 }
 
-type PrefetchStreamTask struct {
-	pattern string
-	keys    map[string]json.RawMessage
-}
-
-func (p *StravaPrefetcher) PrefetchActivityStreams(activityId string) {
-	prefetchTasks := []PrefetchStreamTask{
-		{
-			pattern: `{"datasourceId":%d,"endpoint":"/activities/%s/streams","params":{"key_by_type":true,"keys":"velocity_smooth,time"}}`,
-			keys: map[string]json.RawMessage{
-				"key_by_type": []byte("true"),
-				"keys":        []byte("velocity_smooth,time"),
-			},
-		},
-		{
-			pattern: `{"datasourceId":%d,"endpoint":"/activities/%s/streams","params":{"key_by_type":true,"keys":"heartrate,time"}}`,
-			keys: map[string]json.RawMessage{
-				"key_by_type": []byte("true"),
-				"keys":        []byte("heartrate,time"),
-			},
-		},
-		{
-			pattern: `{"datasourceId":%d,"endpoint":"/activities/%s/streams","params":{"key_by_type":true,"keys":"latlng,time"}}`,
-			keys: map[string]json.RawMessage{
-				"key_by_type": []byte("true"),
-				"keys":        []byte("latlng,time"),
-			},
-		},
-	}
-
-	for _, task := range prefetchTasks {
-		payload := fmt.Sprintf(task.pattern, p.ds.dsInfo.ID, activityId)
-
-		requestHash := HashString(payload)
-		stravaApiQueryFn := p.ds.StravaAPIQueryWithCache(requestHash)
+// PrefetchActivityStreams loads configured streams of the activity, each one along with the time stream as
+// it's requested by Activity queries
+func (p *StravaPrefetcher) PrefetchActivityStreams(activityId string) error {
+	for _, stream := range p.streams {
 		apiReq := &StravaAPIRequest{
 			Endpoint: fmt.Sprintf("/activities/%s/streams", activityId),
-			Params:   task.keys,
+			Params: map[string]json.RawMessage{
+				"key_by_type": []byte("true"),
+				"keys":        []byte(strings.Join([]string{stream, StreamTime}, ",")),
+			},
 		}
-		_, err := stravaApiQueryFn(context.Background(), apiReq)
+		_, err := p.ds.StravaAPIQueryWithCache(p.ctx, apiReq)
 		if err != nil {
-			log.DefaultLogger.Error("Error loading activity", "error", err)
+			return fmt.Errorf("error loading activity %s streams: %w", activityId, err)
 		}
 	}
+	return nil
 }
 
 func (p *StravaPrefetcher) PrefetchActivitiesVariable(limit int) {
 	log.DefaultLogger.Debug("Prefetching variables", "limit", limit)
-	payloadPattern := `{"datasourceId":%d,"endpoint":"athlete/activities","params":{"limit":%d,"per_page":%d,"page":1}}`
-	payload := fmt.Sprintf(payloadPattern, p.ds.dsInfo.ID, limit, limit)
-
-	requestHash := HashString(payload)
-	stravaApiQueryFn := p.ds.StravaAPIQueryWithCache(requestHash)
 	apiReq := &StravaAPIRequest{
 		Endpoint: "athlete/activities",
 		Params: map[string]json.RawMessage{
@@ -162,8 +638,46 @@ func (p *StravaPrefetcher) PrefetchActivitiesVariable(limit int) {
 			"page":     []byte("1"),
 		},
 	}
-	_, err := stravaApiQueryFn(context.Background(), apiReq)
+	_, err := p.ds.StravaAPIQueryWithCache(p.ctx, apiReq)
 	if err != nil {
 		log.DefaultLogger.Error("Error loading activities", "error", err)
 	}
 }
+
+func (p *StravaPrefetcher) loadState() {
+	data, err := os.ReadFile(p.stateFile)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.DefaultLogger.Warn("Cannot read sync state", "path", p.stateFile, "error", err)
+		}
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err = json.Unmarshal(data, &p.state)
+	if err != nil {
+		log.DefaultLogger.Warn("Cannot read sync state, starting from scratch", "path", p.stateFile, "error", err)
+		p.state = SyncState{}
+	}
+}
+
+func (p *StravaPrefetcher) saveState() {
+	if p.stateFile == "" {
+		return
+	}
+
+	data, err := json.Marshal(p.State())
+	if err != nil {
+		log.DefaultLogger.Warn("Cannot serialize sync state", "error", err)
+		return
+	}
+	tmpFile := p.stateFile + ".tmp"
+	err = os.WriteFile(tmpFile, data, 0640)
+	if err == nil {
+		err = os.Rename(tmpFile, p.stateFile)
+	}
+	if err != nil {
+		log.DefaultLogger.Warn("Cannot save sync state", "path", p.stateFile, "error", err)
+	}
+}
@@ -0,0 +1,427 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newTestPrefetcher returns prefetcher of the data source instance which sends Strava API requests to handler
+func newTestPrefetcher(t *testing.T, handler http.HandlerFunc) *StravaPrefetcher {
+	t.Helper()
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Result(), nil
+	})}
+
+	dsInfo := &backend.DataSourceInstanceSettings{ID: 1, UID: fmt.Sprintf("test-%d", time.Now().UnixNano())}
+	policies, err := NewCachePolicies(nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := newTestInstance(client)
+	ds.dsInfo = dsInfo
+	ds.cache = NewDSCache(dsInfo, time.Hour, 0, "", 0, 0)
+	ds.cachePolicies = policies
+	ds.activityFeed = getActivityFeed(dsInfo.UID)
+	ds.ctx, ds.cancel = context.WithCancel(context.Background())
+	ds.cache.Set(accessTokenCacheKey(DefaultAthleteId), "token")
+	t.Cleanup(func() {
+		ds.cancel()
+		ds.cache.Stop()
+		ds.activityFeed.Release()
+	})
+
+	return NewStravaPrefetcher(ds, &StravaDatasourceSettingsDTO{SyncDepth: 3, SyncStreams: []string{"heartrate"}}, t.TempDir())
+}
+
+// activitiesHandler lists activities 1-3 of athlete 1 created after the last synced one. Activities with the
+// given ids respond with status instead of details.
+func activitiesHandler(failed map[string]int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/athlete" {
+			_, _ = w.Write([]byte(`{"id": 1}`))
+			return
+		}
+		if r.URL.Path == "/api/v3/athlete/activities" {
+			if r.URL.Query().Get("after") != "" && r.URL.Query().Get("page") == "1" {
+				_, _ = w.Write([]byte(`[
+					{"id": 1, "start_date": "2024-01-01T10:00:00Z"},
+					{"id": 2, "start_date": "2024-01-02T10:00:00Z"},
+					{"id": 3, "start_date": "2024-01-03T10:00:00Z"}
+				]`))
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+			return
+		}
+
+		var id string
+		if _, err := fmt.Sscanf(r.URL.Path, "/api/v3/activities/%s", &id); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for failedId, status := range failed {
+			if id == failedId || id == failedId+"/streams" {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"message": "error"}`))
+				return
+			}
+		}
+		_, _ = fmt.Fprintf(w, `{"id": %s}`, strings.TrimSuffix(id, "/streams"))
+	}
+}
+
+func TestIsPermanentError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{name: "not found", err: &StravaAPIError{StatusCode: http.StatusNotFound}, permanent: true},
+		{name: "forbidden", err: &StravaAPIError{StatusCode: http.StatusForbidden}, permanent: true},
+		{name: "wrapped", err: fmt.Errorf("error loading activity: %w", &StravaAPIError{StatusCode: http.StatusNotFound}), permanent: true},
+		{name: "too many requests", err: &StravaAPIError{StatusCode: http.StatusTooManyRequests}},
+		{name: "unauthorized", err: &StravaAPIError{StatusCode: http.StatusUnauthorized}},
+		{
+			name: "missing permission",
+			err: &StravaAPIError{StatusCode: http.StatusForbidden, Errors: []StravaFaultError{
+				{Resource: "AccessToken", Field: "activity:read_permission", Code: "missing"},
+			}},
+		},
+		{name: "server error", err: &StravaAPIError{StatusCode: http.StatusBadGateway}},
+		{name: "rate limit", err: &RateLimitError{Window: "short term"}},
+		{name: "canceled", err: context.Canceled},
+		{name: "other", err: errors.New("error")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if permanent := isPermanentError(tt.err); permanent != tt.permanent {
+				t.Errorf("expected %v, got %v", tt.permanent, permanent)
+			}
+		})
+	}
+}
+
+func TestSyncFailedActivities(t *testing.T) {
+	tests := []struct {
+		name             string
+		failed           map[string]int
+		lastActivityId   int64
+		syncedActivities int
+		skipped          []int64
+		lastError        bool
+	}{
+		{name: "all loaded", lastActivityId: 3, syncedActivities: 3},
+		{
+			name:             "deleted activity skipped",
+			failed:           map[string]int{"2": http.StatusNotFound},
+			lastActivityId:   3,
+			syncedActivities: 2,
+			skipped:          []int64{2},
+		},
+		{
+			name:             "missing streams skipped",
+			failed:           map[string]int{"2/streams": http.StatusNotFound},
+			lastActivityId:   3,
+			syncedActivities: 2,
+			skipped:          []int64{2},
+		},
+		{
+			name:           "position held on authorization error",
+			failed:         map[string]int{"2": http.StatusUnauthorized},
+			lastActivityId: 1,
+			// Backfill isn't started after the error
+			syncedActivities: 1,
+			lastError:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPrefetcher(t, activitiesHandler(tt.failed))
+			p.state = SyncState{LastActivityId: 100, LastActivityTime: 1700000000, OldestActivityTime: 1700000000}
+
+			p.Sync()
+
+			state := p.State()
+			if state.LastActivityId != tt.lastActivityId || state.SyncedActivities != tt.syncedActivities {
+				t.Errorf("expected last activity %d and %d synced, got %d and %d",
+					tt.lastActivityId, tt.syncedActivities, state.LastActivityId, state.SyncedActivities)
+			}
+			if !slices.Equal(state.SkippedActivities, tt.skipped) {
+				t.Errorf("expected skipped %v, got %v", tt.skipped, state.SkippedActivities)
+			}
+			if (state.LastError != "") != tt.lastError {
+				t.Errorf("unexpected last error %q", state.LastError)
+			}
+		})
+	}
+}
+
+func TestSyncAfterDispose(t *testing.T) {
+	p := newTestPrefetcher(t, activitiesHandler(nil))
+	p.ds.cancel()
+
+	p.Sync()
+
+	if state := p.State(); state.LastSyncAt != 0 || state.LastError != "" {
+		t.Errorf("expected state not updated, got %+v", state)
+	}
+	if _, err := os.Stat(p.stateFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected state not saved, got %v", err)
+	}
+}
+
+func TestPrefetchActivities(t *testing.T) {
+	tests := []struct {
+		name        string
+		activityIds []int64
+		failed      map[string]int
+		// panicking activities fail to match cache policy
+		panicking bool
+		states    []string
+	}{
+		{name: "no activities", activityIds: []int64{}, states: []string{}},
+		{name: "fewer activities than workers", activityIds: []int64{1}, states: []string{PrefetchTaskDone}},
+		{
+			name:        "more activities than workers",
+			activityIds: []int64{1, 2, 3, 4, 5, 6},
+			failed:      map[string]int{"4": http.StatusNotFound},
+			states: []string{
+				PrefetchTaskDone, PrefetchTaskDone, PrefetchTaskDone,
+				PrefetchTaskFailed, PrefetchTaskDone, PrefetchTaskDone,
+			},
+		},
+		{
+			name:        "panicking task",
+			activityIds: []int64{1, 2},
+			panicking:   true,
+			states:      []string{PrefetchTaskDone, PrefetchTaskFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPrefetcher(t, activitiesHandler(tt.failed))
+			if tt.panicking {
+				// Policy without pattern panics on match, so only activity 1 is prefetched
+				policies, err := NewCachePolicies([]CachePolicySettings{{Pattern: `^activities/1(/.*)?$`}}, time.Hour)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.ds.cachePolicies = append(policies[:1], CachePolicy{})
+			}
+
+			errs := p.PrefetchActivities(tt.activityIds)
+
+			if len(errs) != len(tt.activityIds) {
+				t.Fatalf("expected %d errors, got %d", len(tt.activityIds), len(errs))
+			}
+			status := p.Status().Prefetch
+			if status.Running || status.Total != len(tt.activityIds) {
+				t.Errorf("expected finished prefetch of %d activities, got %+v", len(tt.activityIds), status)
+			}
+			failed := 0
+			for i, state := range tt.states {
+				task := status.Tasks[i]
+				if task.ActivityId != tt.activityIds[i] || task.State != state {
+					t.Errorf("task %d: expected activity %d %s, got %+v", i, tt.activityIds[i], state, task)
+				}
+				if (errs[i] != nil) != (state == PrefetchTaskFailed) || (task.Error != "") != (state == PrefetchTaskFailed) {
+					t.Errorf("task %d: unexpected error %v", i, errs[i])
+				}
+				if state == PrefetchTaskFailed {
+					failed++
+				}
+			}
+			if status.Failed != failed || status.Completed != len(tt.activityIds)-failed {
+				t.Errorf("expected %d failed tasks, got %+v", failed, status)
+			}
+		})
+	}
+}
+
+func TestSyncActivityStoreNewActivities(t *testing.T) {
+	after := int64(1700000000)
+	requested := make([]string, 0)
+	p := newTestPrefetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		// Activity N is started N minutes after the last synced one
+		activity := func(id int) string {
+			startDate := time.Unix(after+int64(id)*60, 0).UTC().Format(time.RFC3339)
+			return fmt.Sprintf(`{"id": %d, "start_date": %q}`, id, startDate)
+		}
+		var id int
+		if _, err := fmt.Sscanf(r.URL.Path, "/api/v3/activities/%d", &id); err == nil {
+			_, _ = w.Write([]byte(activity(id)))
+			return
+		}
+
+		query := r.URL.Query()
+		requested = append(requested, query.Get("after"))
+		// Two pages of activities: full one and the last one with 5 activities
+		count := map[string]int{"1": ActivityStorePageSize, "2": 5}[query.Get("page")]
+		activities := make([]string, 0, count)
+		for i := 0; i < count; i++ {
+			id := i + 1
+			if query.Get("page") == "2" {
+				id += ActivityStorePageSize
+			}
+			activities = append(activities, activity(id))
+		}
+		_, _ = fmt.Fprintf(w, "[%s]", strings.Join(activities, ","))
+	})
+	store, err := openActivityStore(filepath.Join(t.TempDir(), "activities.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Release()
+	p.ds.activityStore = store
+	if err := store.SetState(ActivityStoreState{NewestActivityTime: after, HistoryComplete: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.syncActivityStore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{fmt.Sprint(after), fmt.Sprint(after)}; !slices.Equal(requested, expected) {
+		t.Errorf("expected pages requested after %v, got %v", expected, requested)
+	}
+	state, err := store.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := after + (ActivityStorePageSize+5)*60; state.NewestActivityTime != expected {
+		t.Errorf("expected newest activity time %d, got %d", expected, state.NewestActivityTime)
+	}
+	activities, ok, err := store.Activities(after, after+(ActivityStorePageSize+10)*60)
+	if err != nil || !ok || len(activities) != ActivityStorePageSize+5 {
+		t.Errorf("expected %d stored activities, got %d (%v, %v)", ActivityStorePageSize+5, len(activities), ok, err)
+	}
+	if state.Details != SyncBatchSize {
+		t.Errorf("expected details of %d latest activities, got %d", SyncBatchSize, state.Details)
+	}
+}
+
+func TestSyncAthleteChanged(t *testing.T) {
+	tests := []struct {
+		name           string
+		athleteId      int64
+		lastActivityId int64
+		storeCleared   bool
+	}{
+		{name: "same athlete", athleteId: 1, lastActivityId: 3},
+		{name: "owner not recorded", athleteId: 0, lastActivityId: 3},
+		// Sync starts from scratch, the latest batch is empty in the test handler
+		{name: "another athlete", athleteId: 2, lastActivityId: 0, storeCleared: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPrefetcher(t, activitiesHandler(nil))
+			store := newTestActivityStore(t)
+			p.ds.activityStore = store
+			if err := store.PutActivities([]json.RawMessage{activityJSON(100, 1600000000)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.SetState(ActivityStoreState{AthleteId: tt.athleteId, OldestActivityTime: 1600000000}); err != nil {
+				t.Fatal(err)
+			}
+			p.state = SyncState{AthleteId: tt.athleteId, LastActivityId: 100, LastActivityTime: 1700000000, OldestActivityTime: 1700000000}
+
+			p.Sync()
+
+			state := p.State()
+			if state.AthleteId != 1 || state.LastActivityId != tt.lastActivityId {
+				t.Errorf("expected athlete 1 and last activity %d, got %+v", tt.lastActivityId, state)
+			}
+			storeState, err := store.State()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if storeState.AthleteId != 1 {
+				t.Errorf("expected store of athlete 1, got %+v", storeState)
+			}
+			if _, ok := store.ActivityDetails(100); ok == tt.storeCleared {
+				t.Errorf("expected activity of previous athlete removed %v", tt.storeCleared)
+			}
+		})
+	}
+}
+
+func TestSetDefaultAthlete(t *testing.T) {
+	p := newTestPrefetcher(t, activitiesHandler(nil))
+	p.ds.prefetcher = p
+	store := newTestActivityStore(t)
+	p.ds.activityStore = store
+	if err := store.PutActivityDetails(activityJSON(100, 1600000000)); err != nil {
+		t.Fatal(err)
+	}
+	p.ds.cache.Set("request", "response")
+
+	p.ds.setDefaultAthlete(1)
+	if _, ok := p.ds.cache.Get("request"); !ok {
+		t.Error("expected cache kept when owner is recorded first time")
+	}
+	if _, ok := store.ActivityDetails(100); !ok {
+		t.Error("expected activity store kept when owner is recorded first time")
+	}
+
+	p.ds.setDefaultAthlete(2)
+	if _, ok := p.ds.cache.Get("request"); ok {
+		t.Error("expected cache of previous athlete removed")
+	}
+	if _, ok := store.ActivityDetails(100); ok {
+		t.Error("expected activities of previous athlete removed")
+	}
+	if state := p.State(); state.AthleteId != 2 {
+		t.Errorf("expected sync state of athlete 2, got %+v", state)
+	}
+}
+
+func TestResetCacheClearsSyncData(t *testing.T) {
+	p := newTestPrefetcher(t, activitiesHandler(nil))
+	p.ds.prefetcher = p
+	store := newTestActivityStore(t)
+	p.ds.activityStore = store
+	if err := store.PutActivityDetails(activityJSON(100, 1600000000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetState(ActivityStoreState{AthleteId: 1, HistoryComplete: true}); err != nil {
+		t.Fatal(err)
+	}
+	p.state = SyncState{AthleteId: 1, LastActivityId: 100, SyncedActivities: 1}
+	p.saveState()
+
+	p.ds.ResetCache()
+
+	if state := p.State(); state.AthleteId != 0 || state.LastActivityId != 0 || state.SyncedActivities != 0 {
+		t.Errorf("expected empty sync state, got %+v", state)
+	}
+	saved := SyncState{}
+	data, err := os.ReadFile(p.stateFile)
+	if err == nil {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil || saved.LastActivityId != 0 || saved.SyncedActivities != 0 {
+		t.Errorf("expected empty sync state saved, got %s (%v)", data, err)
+	}
+	storeState, err := store.State()
+	if err != nil || storeState != (ActivityStoreState{}) {
+		t.Errorf("expected empty activity store, got %+v (%v)", storeState, err)
+	}
+}
@@ -0,0 +1,181 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/strava-datasource/pkg/units"
+)
+
+// ActivitiesCacheInterval is used to round query time range in order to hit cache
+const ActivitiesCacheInterval = 5 * 60
+
+// ActivitiesPageSize is a max number of activities Strava API returns per page
+const ActivitiesPageSize = 200
+
+// Query handles a single data query and builds data frames for it
+func (ds *StravaDatasourceInstance) Query(ctx context.Context, query QueryModel) backend.DataResponse {
+	ctx, staleTracker := withStaleTracker(ctx)
+
+	var queryFn func(context.Context, QueryModel, units.MeasurementPreference) (data.Frames, error)
+	switch query.QueryType {
+	case QueryTypeActivities:
+		queryFn = ds.queryActivities
+	case QueryTypeActivity:
+		queryFn = ds.queryActivity
+	case QueryTypeSegmentEffort:
+		queryFn = ds.querySegmentEffort
+	default:
+		return errorResponse(fmt.Errorf("query type not supported: %s", query.QueryType))
+	}
+
+	// Query doesn't fail if athlete's preference can't be loaded, metric units are used instead
+	mp, mpErr := ds.getMeasurementPreference(ctx, query)
+	if mpErr != nil {
+		ds.logger.Warn("Cannot get measurement preference, metric units are used", "error", mpErr)
+	}
+
+	frames, err := queryFn(ctx, query, mp)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if staleTracker.stale.Load() {
+		setStaleNotice(frames)
+	}
+	if mpErr != nil {
+		setUnitsNotice(frames)
+	}
+	return backend.DataResponse{Frames: frames}
+}
+
+// setUnitsNotice adds notice to the frames built with metric units because athlete's preference is unknown
+func setUnitsNotice(frames data.Frames) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     "Cannot get athlete's measurement preference, metric units are used",
+		})
+	}
+}
+
+// errorResponse returns data response with status matching the error, Strava API errors are reported
+// as downstream ones
+func errorResponse(err error) backend.DataResponse {
+	status := backend.Status(errorStatusCode(err))
+	if isDownstreamError(err) {
+		return backend.ErrDataResponseWithSource(status, backend.ErrorSourceDownstream, err.Error())
+	}
+	return backend.ErrDataResponse(status, err.Error())
+}
+
+func (ds *StravaDatasourceInstance) queryActivities(ctx context.Context, query QueryModel, mp units.MeasurementPreference) (data.Frames, error) {
+	before := query.TimeRange.To.Unix() / ActivitiesCacheInterval * ActivitiesCacheInterval
+	after := query.TimeRange.From.Unix() / ActivitiesCacheInterval * ActivitiesCacheInterval
+	activities, err := ds.GetActivities(ctx, before, after, query.AthleteId, query.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	activities = filterActivities(activities, query.ActivityType)
+
+	var frame *data.Frame
+	switch query.Format {
+	case FormatTable:
+		frame = transformActivitiesToTable(activities, query, mp)
+	case FormatWorldMap:
+		frame = transformActivitiesToGeomap(activities, query, mp)
+	case FormatHeatmap:
+		frame = transformActivitiesToHeatmap(activities, query)
+	default:
+		frame = transformActivitiesToTimeseries(activities, query, mp)
+	}
+	return data.Frames{frame}, nil
+}
+
+// GetActivities fetches all athlete activities between after and before timestamps page by page
+func (ds *StravaDatasourceInstance) GetActivities(ctx context.Context, before int64, after int64, athleteId int64, accessToken string) ([]StravaActivity, error) {
+	if ds.useActivityStore(athleteId, accessToken) {
+		activities, ok, err := ds.activityStore.Activities(after, before)
+		if err != nil {
+			ds.logger.Warn("Error reading activity store", "error", err)
+		} else if ok {
+			return activities, nil
+		}
+	}
+
+	activities := make([]StravaActivity, 0)
+
+	for page := 1; ; page++ {
+		apiReq := &StravaAPIRequest{
+			Endpoint: "athlete/activities",
+			Params: map[string]json.RawMessage{
+				"before":   []byte(fmt.Sprintf("%d", before)),
+				"after":    []byte(fmt.Sprintf("%d", after)),
+				"per_page": []byte(fmt.Sprintf("%d", ActivitiesPageSize)),
+				"page":     []byte(fmt.Sprintf("%d", page)),
+			},
+			AthleteId:   athleteId,
+			AccessToken: accessToken,
+		}
+		resp, err := ds.StravaAPIQueryWithCache(ctx, apiReq)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching activities: %w", err)
+		}
+
+		chunk := make([]StravaActivity, 0)
+		err = resp.Unmarshal(&chunk)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing activities: %w", err)
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		activities = append(activities, chunk...)
+	}
+
+	return activities, nil
+}
+
+// useActivityStore returns true if activities of the athlete are kept in the activity store
+func (ds *StravaDatasourceInstance) useActivityStore(athleteId int64, accessToken string) bool {
+	return ds.activityStore != nil && athleteId == DefaultAthleteId && accessToken == ""
+}
+
+// GetAthlete returns currently authenticated athlete
+func (ds *StravaDatasourceInstance) GetAthlete(ctx context.Context, athleteId int64, accessToken string) (*StravaAthlete, error) {
+	resp, err := ds.StravaAPIQueryWithCache(ctx, &StravaAPIRequest{Endpoint: "athlete", AthleteId: athleteId, AccessToken: accessToken})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching athlete: %w", err)
+	}
+
+	athlete := &StravaAthlete{}
+	err = resp.Unmarshal(athlete)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing athlete: %w", err)
+	}
+	return athlete, nil
+}
+
+// getMeasurementPreference returns measurement preference set in the query or data source settings,
+// or athlete's preference if not overridden.
+func (ds *StravaDatasourceInstance) getMeasurementPreference(ctx context.Context, query QueryModel) (units.MeasurementPreference, error) {
+	if mp, ok := units.Parse(query.MeasurementPreference); ok {
+		return mp, nil
+	}
+	if mp, ok := units.Parse(ds.settings.MeasurementPreference); ok {
+		return mp, nil
+	}
+
+	athlete, err := ds.GetAthlete(ctx, query.AthleteId, query.AccessToken)
+	if err != nil {
+		return units.Meters, err
+	}
+	mp, _ := units.Parse(athlete.MeasurementPreference)
+	return mp, nil
+}
@@ -0,0 +1,520 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/strava-datasource/pkg/units"
+)
+
+// smoothedStreams contains streams which are smoothed with moving average before displaying
+var smoothedStreams = []string{StreamVelocity, StreamHeartRate, StreamGradeSmooth, StreamWattsCalc, StreamWatts}
+
+// geomapStreams contains streams fetched for the activity geomap
+var geomapStreams = []string{StreamLatLng, StreamVelocity, StreamAltitude, StreamGradeSmooth, StreamHeartRate}
+
+func (ds *StravaDatasourceInstance) queryActivity(ctx context.Context, query QueryModel, mp units.MeasurementPreference) (data.Frames, error) {
+	if !query.ActivityId.Valid() {
+		return data.Frames{}, nil
+	}
+	activityId := query.ActivityId.String()
+
+	activity, err := ds.GetActivity(ctx, activityId, query.AthleteId, query.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var frame *data.Frame
+	switch query.ActivityData {
+	case ActivityDataStats:
+		frame = transformActivityStats(activity, query, mp)
+	case ActivityDataSplits:
+		frame = transformActivitySplits(activity, query, mp)
+	case ActivityDataGeomap:
+		frame = ds.queryActivityGeomap(ctx, activity, query)
+	case ActivityDataSegments:
+		frame, err = ds.queryActivitySegments(ctx, activity, query, mp)
+	default:
+		frame, err = ds.queryActivityGraph(ctx, activity, query, mp)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if frame == nil {
+		return data.Frames{}, nil
+	}
+	return data.Frames{frame}, nil
+}
+
+func (ds *StravaDatasourceInstance) queryActivityGraph(ctx context.Context, activity *StravaActivity, query QueryModel, mp units.MeasurementPreference) (*data.Frame, error) {
+	activityStream := query.ActivityGraph
+	if activityStream == StreamPace {
+		activityStream = StreamVelocity
+	}
+	if activityStream == "" {
+		return nil, nil
+	}
+
+	streams, err := ds.GetActivityStreams(ctx, fmt.Sprintf("%d", activity.Id), []string{activityStream}, query.AthleteId, query.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, ok := streams[activityStream]
+	if !ok {
+		return newActivityFrame(activity, query), nil
+	}
+
+	timeStream := streams[StreamTime]
+	timeTicks := timeStream.TimeTicks()
+	streamLength := timeStream.OriginalSize
+	if streamLength == 0 && len(timeTicks) > 0 {
+		streamLength = int(timeTicks[len(timeTicks)-1]) + 1
+	}
+
+	startTS := activity.StartDate.Unix()
+	if query.FitToTimeRange {
+		startTS = query.TimeRange.From.Unix()
+	}
+
+	values, ticks := expandDataStream(stream.Values(), timeTicks, startTS, 0, streamLength-1)
+	return buildStreamFrame(activity, query, query.ActivityGraph, values, ticks, mp), nil
+}
+
+// buildStreamFrame converts and smooths stream values and returns time series frame
+func buildStreamFrame(activity *StravaActivity, query QueryModel, streamType string, values []*float64, ticks []time.Time, mp units.MeasurementPreference) *data.Frame {
+	valueName := streamType
+	unit := ""
+
+	switch streamType {
+	case StreamPace:
+		if isRun(activity.SportType) {
+			valueName = "pace"
+			unit = units.UnitDuration
+			values = convertData(values, mp.Pace)
+		} else {
+			valueName = "speed"
+			unit = mp.SpeedUnit()
+			values = convertData(values, mp.Speed)
+		}
+	case StreamVelocity:
+		valueName = "speed"
+		unit = mp.SpeedUnit()
+		values = convertData(values, mp.Speed)
+	case StreamAltitude:
+		unit = mp.LengthUnit()
+		values = convertData(values, mp.Length)
+	}
+
+	smoothStream := streamType
+	if smoothStream == StreamPace {
+		smoothStream = StreamVelocity
+	}
+	for _, s := range smoothedStreams {
+		if s == smoothStream {
+			values = smoothData(values)
+			break
+		}
+	}
+
+	valueField := data.NewField(valueName, nil, values)
+	if unit != "" {
+		valueField.Config = &data.FieldConfig{Unit: unit}
+	}
+
+	frame := data.NewFrame(activity.Name,
+		data.NewField(data.TimeSeriesTimeFieldName, nil, ticks),
+		valueField,
+	)
+	frame.RefID = query.RefID
+	return frame
+}
+
+func newActivityFrame(activity *StravaActivity, query QueryModel) *data.Frame {
+	frame := data.NewFrame(activity.Name)
+	frame.RefID = query.RefID
+	return frame
+}
+
+func transformActivitySplits(activity *StravaActivity, query QueryModel, mp units.MeasurementPreference) *data.Frame {
+	splitStat := query.SplitStat
+	valueName := splitStat
+	if valueName == "" {
+		valueName = data.TimeSeriesValueFieldName
+	}
+
+	ts := activity.StartDate.Unix()
+	if query.FitToTimeRange {
+		ts = query.TimeRange.From.Unix()
+	}
+
+	splits := activity.SplitsMetric
+	if mp == units.Feet {
+		splits = activity.SplitsStandard
+	}
+
+	unit := ""
+	timeValues := make([]time.Time, 0, len(splits))
+	values := make([]*float64, 0, len(splits))
+	for _, split := range splits {
+		timeValues = append(timeValues, time.Unix(ts, 0))
+		value := split.Stat(splitStat)
+
+		averageSpeed := split.Stat(SplitStatSpeed)
+		if splitStat == SplitStatSpeed && value != nil {
+			unit = mp.SpeedUnit()
+			speed := mp.Speed(*value)
+			value = &speed
+		} else if splitStat == SplitStatPace && averageSpeed != nil {
+			var pace float64
+			pace, unit = getPaceOrSpeed(*averageSpeed, isRun(activity.SportType), mp)
+			value = &pace
+		}
+		values = append(values, value)
+
+		if elapsedTime := split.Stat("elapsed_time"); elapsedTime != nil {
+			ts += int64(*elapsedTime)
+		}
+	}
+
+	valueField := data.NewField(valueName, nil, values)
+	if unit != "" {
+		valueField.Config = &data.FieldConfig{Unit: unit}
+	}
+
+	frame := data.NewFrame(activity.Name,
+		data.NewField(data.TimeSeriesTimeFieldName, nil, timeValues),
+		valueField,
+	)
+	frame.RefID = query.RefID
+	return frame
+}
+
+func transformActivityStats(activity *StravaActivity, query QueryModel, mp units.MeasurementPreference) *data.Frame {
+	stat := query.SingleActivityStat
+	if stat == "" {
+		stat = "name"
+	}
+
+	unit := ""
+	rawValue, _ := activity.Field(stat)
+	var value *float64
+
+	switch {
+	case strings.HasPrefix(stat, "gear_"):
+		rawValue = nil
+		gearStatName := strings.TrimPrefix(stat, "gear_")
+		gear := make(map[string]json.RawMessage)
+		if rawGear, ok := activity.Field("gear"); ok && json.Unmarshal(rawGear, &gear) == nil {
+			rawValue = gear[gearStatName]
+		}
+	case stat == SplitStatPace:
+		averageSpeed := activity.Stat(SplitStatSpeed)
+		if averageSpeed != nil {
+			var pace float64
+			pace, unit = getPaceOrSpeed(*averageSpeed, isRun(activity.SportType), mp)
+			value = &pace
+		}
+	case stat == TopAchievementStat:
+		for _, effort := range activity.SegmentEfforts {
+			for _, achievement := range effort.Achievements {
+				if value == nil || float64(achievement.Rank) < *value {
+					rank := float64(achievement.Rank)
+					value = &rank
+				}
+			}
+		}
+	case stat == ActivityStatDistance:
+		unit = mp.DistanceUnit()
+		distance := mp.Distance(activity.Distance)
+		value = &distance
+	case stat == ActivityStatElevationGain:
+		unit = mp.LengthUnit()
+		elevationGain := mp.Length(activity.TotalElevationGain)
+		value = &elevationGain
+	}
+
+	var valueField *data.Field
+	if value != nil || rawValue == nil || string(rawValue) == "null" {
+		valueField = data.NewField(stat, nil, []*float64{value})
+	} else {
+		var numericValue float64
+		var stringValue string
+		if err := json.Unmarshal(rawValue, &numericValue); err == nil {
+			valueField = data.NewField(stat, nil, []*float64{&numericValue})
+		} else if err := json.Unmarshal(rawValue, &stringValue); err == nil {
+			valueField = data.NewField(stat, nil, []*string{&stringValue})
+		} else {
+			stringValue = string(rawValue)
+			valueField = data.NewField(stat, nil, []*string{&stringValue})
+		}
+	}
+	if unit != "" {
+		valueField.Config = &data.FieldConfig{Unit: unit}
+	}
+
+	frame := data.NewFrame(activity.Name,
+		data.NewField(data.TimeSeriesTimeFieldName, nil, []time.Time{activity.StartDate}),
+		valueField,
+	)
+	frame.RefID = query.RefID
+	return frame
+}
+
+func (ds *StravaDatasourceInstance) queryActivitySegments(ctx context.Context, activity *StravaActivity, query QueryModel, mp units.MeasurementPreference) (*data.Frame, error) {
+	paceUnit := ""
+	names := make([]string, 0)
+	achievements := make([]*int64, 0)
+	movingTimes := make([]int64, 0)
+	paces := make([]float64, 0)
+	heartRates := make([]*float64, 0)
+	powers := make([]*float64, 0)
+	distances := make([]float64, 0)
+	elevationGains := make([]float64, 0)
+	grades := make([]float64, 0)
+	prs := make([]*string, 0)
+	koms := make([]*string, 0)
+	ids := make([]string, 0)
+	segmentIds := make([]string, 0)
+	timeFrom := make([]int64, 0)
+	timeTo := make([]int64, 0)
+
+	for _, effort := range activity.SegmentEfforts {
+		segment, err := ds.GetSegment(ctx, effort.Segment.Id, query.AthleteId, query.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+
+		var pace float64
+		velocity := 0.0
+		if effort.MovingTime > 0 {
+			velocity = effort.Distance / float64(effort.MovingTime)
+		}
+		pace, paceUnit = getPaceOrSpeed(velocity, isRun(effort.Segment.ActivityType), mp)
+
+		var pr, kom *string
+		if segment.AthleteSegmentStats != nil && segment.AthleteSegmentStats.PrElapsedTime != nil {
+			prValue := fmt.Sprintf("%d", *segment.AthleteSegmentStats.PrElapsedTime)
+			pr = &prValue
+		}
+		if segment.Xoms != nil {
+			kom = &segment.Xoms.Overall
+		}
+
+		names = append(names, effort.Name)
+		achievements = append(achievements, effort.PrRank)
+		movingTimes = append(movingTimes, effort.MovingTime)
+		paces = append(paces, pace)
+		heartRates = append(heartRates, effort.AverageHeartrate)
+		powers = append(powers, effort.AverageWatts)
+		distances = append(distances, mp.Distance(effort.Distance))
+		elevationGains = append(elevationGains, mp.Length(effort.Segment.ElevationHigh-effort.Segment.ElevationLow))
+		grades = append(grades, effort.Segment.AverageGrade)
+		prs = append(prs, pr)
+		koms = append(koms, kom)
+		ids = append(ids, fmt.Sprintf("%d", effort.Id))
+		segmentIds = append(segmentIds, fmt.Sprintf("%d", effort.Segment.Id))
+		timeFrom = append(timeFrom, effort.StartDate.Unix()*1000)
+		timeTo = append(timeTo, (effort.StartDate.Unix()+effort.ElapsedTime)*1000)
+	}
+
+	frame := data.NewFrame("",
+		data.NewField("name", nil, names),
+		data.NewField("achievements", nil, achievements).SetConfig(&data.FieldConfig{Decimals: decimals(0)}),
+		data.NewField("time", nil, movingTimes).SetConfig(&data.FieldConfig{Unit: units.UnitDuration}),
+		data.NewField("pace", nil, paces).SetConfig(&data.FieldConfig{Unit: paceUnit}),
+		data.NewField("heart rate", nil, heartRates).SetConfig(&data.FieldConfig{Unit: units.UnitBPM, Decimals: decimals(0)}),
+		data.NewField("power", nil, powers).SetConfig(&data.FieldConfig{Unit: units.UnitWatt}),
+		data.NewField("distance", nil, distances).SetConfig(&data.FieldConfig{Unit: mp.DistanceUnit()}),
+		data.NewField("elevation gain", nil, elevationGains).SetConfig(&data.FieldConfig{Unit: mp.LengthUnit(), Decimals: decimals(0)}),
+		data.NewField("grade", nil, grades).SetConfig(&data.FieldConfig{Unit: units.UnitPercent, Decimals: decimals(1)}),
+		data.NewField("PR", nil, prs).SetConfig(&data.FieldConfig{Unit: units.UnitDuration}),
+		data.NewField("KOM", nil, koms),
+		data.NewField("id", nil, ids).SetConfig(hiddenFieldConfig()),
+		data.NewField("segment_id", nil, segmentIds).SetConfig(hiddenFieldConfig()),
+		data.NewField("time_from", nil, timeFrom).SetConfig(hiddenTimeFieldConfig()),
+		data.NewField("time_to", nil, timeTo).SetConfig(hiddenTimeFieldConfig()),
+	)
+	frame.RefID = query.RefID
+	return frame, nil
+}
+
+// queryActivityGeomap returns activity track points with stream values. If streams are not available,
+// points decoded from the activity polyline are returned.
+func (ds *StravaDatasourceInstance) queryActivityGeomap(ctx context.Context, activity *StravaActivity, query QueryModel) *data.Frame {
+	streams, err := ds.GetActivityStreams(ctx, fmt.Sprintf("%d", activity.Id), geomapStreams, query.AthleteId, query.AccessToken)
+	if err == nil {
+		_, hasLatLng := streams[StreamLatLng]
+		_, hasTime := streams[StreamTime]
+		if hasLatLng && hasTime {
+			latLng := streams[StreamLatLng].LatLng()
+			return buildGeomapFrame(activity, query, streams, latLng, 0, len(latLng))
+		}
+	}
+
+	ds.logger.Debug("Cannot fetch geo points from activity stream, switching to polyline", "error", err)
+	points := decodePolyline(activity.Map.Polyline)
+	timeValues := make([]*time.Time, len(points))
+	latitudes := make([]float64, 0, len(points))
+	longitudes := make([]float64, 0, len(points))
+	emptyValues := make([]*float64, len(points))
+	for _, point := range points {
+		latitudes = append(latitudes, point[0])
+		longitudes = append(longitudes, point[1])
+	}
+
+	frame := data.NewFrame(activity.Name,
+		data.NewField(data.TimeSeriesTimeFieldName, nil, timeValues),
+		data.NewField("latitude", nil, latitudes),
+		data.NewField("longitude", nil, longitudes),
+		data.NewField("velocity", nil, emptyValues),
+		data.NewField("altitude", nil, emptyValues),
+		data.NewField("grade", nil, emptyValues),
+		data.NewField("heartrate", nil, emptyValues),
+	)
+	frame.RefID = query.RefID
+	return frame
+}
+
+// buildGeomapFrame builds geomap frame from the latlng points within [startIndex, endIndex) range
+func buildGeomapFrame(activity *StravaActivity, query QueryModel, streams StravaStreamSet, latLng [][2]float64, startIndex int, endIndex int) *data.Frame {
+	timeTicks := streams[StreamTime].TimeTicks()
+	velocity := streams[StreamVelocity].Values()
+	altitude := streams[StreamAltitude].Values()
+	grade := streams[StreamGradeSmooth].Values()
+	heartrate := streams[StreamHeartRate].Values()
+
+	valueAt := func(values []*float64, i int) *float64 {
+		if i < len(values) {
+			return values[i]
+		}
+		return nil
+	}
+
+	startTS := activity.StartDate.Unix()
+	endIndex = min(endIndex, len(latLng), len(timeTicks))
+	startIndex = max(0, min(startIndex, endIndex))
+	size := endIndex - startIndex
+
+	timeValues := make([]*time.Time, 0, size)
+	latitudes := make([]float64, 0, size)
+	longitudes := make([]float64, 0, size)
+	velocityValues := make([]*float64, 0, size)
+	altitudeValues := make([]*float64, 0, size)
+	gradeValues := make([]*float64, 0, size)
+	heartrateValues := make([]*float64, 0, size)
+
+	for i := startIndex; i < endIndex; i++ {
+		ts := time.Unix(startTS+timeTicks[i], 0)
+		timeValues = append(timeValues, &ts)
+		latitudes = append(latitudes, latLng[i][0])
+		longitudes = append(longitudes, latLng[i][1])
+		velocityValues = append(velocityValues, valueAt(velocity, i))
+		altitudeValues = append(altitudeValues, valueAt(altitude, i))
+		gradeValues = append(gradeValues, valueAt(grade, i))
+		heartrateValues = append(heartrateValues, valueAt(heartrate, i))
+	}
+
+	frame := data.NewFrame(activity.Name,
+		data.NewField(data.TimeSeriesTimeFieldName, nil, timeValues),
+		data.NewField("latitude", nil, latitudes),
+		data.NewField("longitude", nil, longitudes),
+		data.NewField("velocity", nil, velocityValues),
+		data.NewField("altitude", nil, altitudeValues),
+		data.NewField("grade", nil, gradeValues),
+		data.NewField("heartrate", nil, heartrateValues),
+	)
+	frame.RefID = query.RefID
+	return frame
+}
+
+// GetActivity returns detailed activity including all segment efforts
+func (ds *StravaDatasourceInstance) GetActivity(ctx context.Context, activityId string, athleteId int64, accessToken string) (*StravaActivity, error) {
+	useStore := ds.useActivityStore(athleteId, accessToken)
+	if id, err := strconv.ParseInt(activityId, 10, 64); useStore && err == nil {
+		if activity, ok := ds.activityStore.ActivityDetails(id); ok {
+			return activity, nil
+		}
+	}
+
+	apiReq := &StravaAPIRequest{
+		Endpoint: fmt.Sprintf("/activities/%s", activityId),
+		Params: map[string]json.RawMessage{
+			"include_all_efforts": []byte("true"),
+		},
+		AthleteId:   athleteId,
+		AccessToken: accessToken,
+	}
+	resp, err := ds.StravaAPIQueryWithCache(ctx, apiReq)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching activity: %w", err)
+	}
+
+	activity := &StravaActivity{}
+	err = resp.Unmarshal(activity)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing activity: %w", err)
+	}
+
+	if useStore {
+		data, err := json.Marshal(resp.Result)
+		if err == nil {
+			err = ds.activityStore.PutActivityDetails(data)
+		}
+		if err != nil {
+			ds.logger.Warn("Error saving activity to the store", "activityId", activityId, "error", err)
+		}
+	}
+	return activity, nil
+}
+
+// GetActivityStreams returns requested activity streams along with the time stream
+func (ds *StravaDatasourceInstance) GetActivityStreams(ctx context.Context, activityId string, streamTypes []string, athleteId int64, accessToken string) (StravaStreamSet, error) {
+	keys := strings.Join(streamTypes, ",") + "," + StreamTime
+	apiReq := &StravaAPIRequest{
+		Endpoint: fmt.Sprintf("/activities/%s/streams", activityId),
+		Params: map[string]json.RawMessage{
+			"key_by_type": []byte("true"),
+			"keys":        []byte(keys),
+		},
+		AthleteId:   athleteId,
+		AccessToken: accessToken,
+	}
+	resp, err := ds.StravaAPIQueryWithCache(ctx, apiReq)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching activity streams: %w", err)
+	}
+
+	streams := make(StravaStreamSet)
+	err = resp.Unmarshal(&streams)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing activity streams: %w", err)
+	}
+	return streams, nil
+}
+
+// GetSegment returns detailed segment
+func (ds *StravaDatasourceInstance) GetSegment(ctx context.Context, segmentId int64, athleteId int64, accessToken string) (*Segment, error) {
+	apiReq := &StravaAPIRequest{
+		Endpoint:    fmt.Sprintf("/segments/%d", segmentId),
+		AthleteId:   athleteId,
+		AccessToken: accessToken,
+	}
+	resp, err := ds.StravaAPIQueryWithCache(ctx, apiReq)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching segment: %w", err)
+	}
+
+	segment := &Segment{}
+	err = resp.Unmarshal(segment)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing segment: %w", err)
+	}
+	return segment, nil
+}
@@ -0,0 +1,91 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/strava-datasource/pkg/units"
+)
+
+func (ds *StravaDatasourceInstance) querySegmentEffort(ctx context.Context, query QueryModel, mp units.MeasurementPreference) (data.Frames, error) {
+	if !query.ActivityId.Valid() || !query.SegmentEffortId.Valid() {
+		return data.Frames{}, nil
+	}
+	activityId := query.ActivityId.String()
+	segmentEffortId := query.SegmentEffortId.String()
+
+	activity, err := ds.GetActivity(ctx, activityId, query.AthleteId, query.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	segmentEffort := findSegmentEffort(activity, segmentEffortId)
+	if segmentEffort == nil {
+		return data.Frames{}, nil
+	}
+
+	var frame *data.Frame
+	if query.SegmentData == ActivityDataGeomap {
+		frame, err = ds.querySegmentGeomap(ctx, activity, segmentEffort, query)
+	} else {
+		frame, err = ds.querySegmentGraph(ctx, activity, segmentEffort, query, mp)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if frame == nil {
+		return data.Frames{}, nil
+	}
+	return data.Frames{frame}, nil
+}
+
+func findSegmentEffort(activity *StravaActivity, segmentEffortId string) *SegmentEffort {
+	for i, effort := range activity.SegmentEfforts {
+		if fmt.Sprintf("%d", effort.Id) == segmentEffortId {
+			return &activity.SegmentEfforts[i]
+		}
+	}
+	return nil
+}
+
+// querySegmentGraph returns requested stream sliced by the effort start and end indexes
+func (ds *StravaDatasourceInstance) querySegmentGraph(ctx context.Context, activity *StravaActivity, segmentEffort *SegmentEffort, query QueryModel, mp units.MeasurementPreference) (*data.Frame, error) {
+	segmentStream := query.SegmentGraph
+	if segmentStream == StreamPace {
+		segmentStream = StreamVelocity
+	}
+	if segmentStream == "" {
+		return nil, nil
+	}
+
+	streams, err := ds.GetActivityStreams(ctx, fmt.Sprintf("%d", activity.Id), []string{segmentStream}, query.AthleteId, query.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, ok := streams[segmentStream]
+	if !ok {
+		return newActivityFrame(activity, query), nil
+	}
+
+	values, ticks := expandDataStream(
+		stream.Values(),
+		streams[StreamTime].TimeTicks(),
+		activity.StartDate.Unix(),
+		segmentEffort.StartIndex,
+		segmentEffort.EndIndex,
+	)
+	return buildStreamFrame(activity, query, query.SegmentGraph, values, ticks, mp), nil
+}
+
+func (ds *StravaDatasourceInstance) querySegmentGeomap(ctx context.Context, activity *StravaActivity, segmentEffort *SegmentEffort, query QueryModel) (*data.Frame, error) {
+	streams, err := ds.GetActivityStreams(ctx, fmt.Sprintf("%d", activity.Id), geomapStreams, query.AthleteId, query.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	latLng := streams[StreamLatLng].LatLng()
+	return buildGeomapFrame(activity, query, streams, latLng, segmentEffort.StartIndex, segmentEffort.EndIndex), nil
+}
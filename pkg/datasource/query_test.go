@@ -0,0 +1,71 @@
+package datasource
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestQueryMeasurementPreference(t *testing.T) {
+	tests := []struct {
+		name          string
+		queryType     string
+		athleteStatus int
+		err           string
+		notice        bool
+		athleteLoaded bool
+	}{
+		{name: "athlete preference", queryType: QueryTypeActivities, athleteStatus: http.StatusOK, athleteLoaded: true},
+		{name: "athlete not loaded", queryType: QueryTypeActivities, athleteStatus: http.StatusNotFound, notice: true, athleteLoaded: true},
+		{name: "unsupported query type", queryType: "unknown", athleteStatus: http.StatusNotFound, err: "query type not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var athleteRequests atomic.Int32
+			handler := activitiesHandler(nil)
+			p := newTestPrefetcher(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/v3/athlete" {
+					athleteRequests.Add(1)
+					w.WriteHeader(tt.athleteStatus)
+					_, _ = w.Write([]byte(`{"id": 1, "measurement_preference": "feet"}`))
+					return
+				}
+				handler(w, r)
+			})
+			p.ds.settings = &StravaDatasourceSettingsDTO{}
+
+			now := time.Now()
+			resp := p.ds.Query(context.Background(), QueryModel{
+				QueryType: tt.queryType,
+				Format:    FormatTable,
+				TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+			})
+
+			if tt.err != "" {
+				if resp.Error == nil || !strings.Contains(resp.Error.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, resp.Error)
+				}
+			} else if resp.Error != nil {
+				t.Fatalf("unexpected error %v", resp.Error)
+			}
+			if loaded := athleteRequests.Load() > 0; loaded != tt.athleteLoaded {
+				t.Errorf("expected athlete loaded %v, got %v", tt.athleteLoaded, loaded)
+			}
+			notice := false
+			for _, frame := range resp.Frames {
+				if frame.Meta != nil && len(frame.Meta.Notices) > 0 {
+					notice = true
+				}
+			}
+			if notice != tt.notice {
+				t.Errorf("expected units notice %v, got %v", tt.notice, notice)
+			}
+		})
+	}
+}
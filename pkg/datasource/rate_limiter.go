@@ -0,0 +1,215 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default Strava API limits, used until actual ones are reported in the response headers
+// https://developers.strava.com/docs/rate-limits/
+const (
+	DefaultShortTermRateLimit = 100
+	DefaultDailyRateLimit     = 1000
+)
+
+// RateLimitInteractiveReserve is a fraction of each window budget available to interactive requests only.
+// Background requests (prefetcher) are rejected when remaining budget drops below it.
+const RateLimitInteractiveReserve = 0.2
+
+const (
+	RateLimitWindowShortTerm = "15-minute"
+	RateLimitWindowDaily     = "daily"
+)
+
+var ErrRateLimitExceeded = errors.New("Strava API rate limit exceeded")
+
+// RateLimitError is returned when request is rejected to keep API usage within the limits
+type RateLimitError struct {
+	Window  string
+	Limit   int
+	Usage   int
+	ResetAt time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s: %s usage %d of %d, resets at %s", ErrRateLimitExceeded.Error(), e.Window, e.Usage, e.Limit, e.ResetAt.UTC().Format(time.RFC3339))
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimitExceeded
+}
+
+type requestPriorityKey struct{}
+
+// WithBackgroundPriority marks requests made with context as background ones, which can't use budget reserved
+// for interactive requests.
+func WithBackgroundPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestPriorityKey{}, true)
+}
+
+func isBackgroundRequest(ctx context.Context) bool {
+	background, _ := ctx.Value(requestPriorityKey{}).(bool)
+	return background
+}
+
+// rateLimitWindow tracks usage within the single rate limit window
+type rateLimitWindow struct {
+	name  string
+	limit int
+	usage int
+	start time.Time
+}
+
+// RateLimiter tracks Strava API usage reported in X-RateLimit-* headers for 15-minute and daily windows.
+// Strava resets 15-minute window at natural 15-minute intervals and daily window at midnight UTC.
+type RateLimiter struct {
+	mu        sync.Mutex
+	shortTerm rateLimitWindow
+	daily     rateLimitWindow
+	now       func() time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		shortTerm: rateLimitWindow{name: RateLimitWindowShortTerm, limit: DefaultShortTermRateLimit},
+		daily:     rateLimitWindow{name: RateLimitWindowDaily, limit: DefaultDailyRateLimit},
+		now:       time.Now,
+	}
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*RateLimiter)
+)
+
+// getRateLimiter returns rate limiter for the Strava application. Limits are applied per application, so
+// data sources with the same client ID share the limiter. Limiter outlives data source instance, which is
+// re-created when settings are changed.
+func getRateLimiter(clientID string) *RateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	limiter, ok := rateLimiters[clientID]
+	if !ok {
+		limiter = NewRateLimiter()
+		rateLimiters[clientID] = limiter
+	}
+	return limiter
+}
+
+// Allow checks if request fits into the remaining budget and counts it. Usage is corrected with actual
+// values once response headers are received.
+func (l *RateLimiter) Allow(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.resetExpired(now)
+
+	reserve := 0.0
+	if isBackgroundRequest(ctx) {
+		reserve = RateLimitInteractiveReserve
+	}
+	for _, w := range []*rateLimitWindow{&l.shortTerm, &l.daily} {
+		budget := int(float64(w.limit) * (1 - reserve))
+		if w.usage >= budget {
+			return &RateLimitError{
+				Window:  w.name,
+				Limit:   w.limit,
+				Usage:   w.usage,
+				ResetAt: l.windowEnd(w),
+			}
+		}
+	}
+
+	l.shortTerm.usage++
+	l.daily.usage++
+	return nil
+}
+
+// Update sets limits and usage reported by Strava. Read limits are preferred if present since they're
+// lower than overall limits and all plugin requests are reads.
+func (l *RateLimiter) Update(header http.Header, statusCode int) {
+	limits, okLimits := parseRateLimitHeader(header.Get("X-ReadRateLimit-Limit"))
+	usage, okUsage := parseRateLimitHeader(header.Get("X-ReadRateLimit-Usage"))
+	if !okLimits || !okUsage {
+		limits, okLimits = parseRateLimitHeader(header.Get("X-RateLimit-Limit"))
+		usage, okUsage = parseRateLimitHeader(header.Get("X-RateLimit-Usage"))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.resetExpired(l.now())
+	if okLimits && okUsage {
+		l.shortTerm.limit, l.shortTerm.usage = limits[0], usage[0]
+		l.daily.limit, l.daily.usage = limits[1], usage[1]
+	}
+	if statusCode == http.StatusTooManyRequests {
+		// Limit is exceeded, so block requests until window is reset even if headers are missing
+		if l.daily.usage >= l.daily.limit {
+			return
+		}
+		l.shortTerm.usage = max(l.shortTerm.usage, l.shortTerm.limit)
+	}
+}
+
+// ResetIn returns time until all exceeded windows are reset, zero if no window is exceeded
+func (l *RateLimiter) ResetIn() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.resetExpired(now)
+
+	resetIn := time.Duration(0)
+	for _, w := range []*rateLimitWindow{&l.shortTerm, &l.daily} {
+		if w.usage >= w.limit {
+			resetIn = max(resetIn, l.windowEnd(w).Sub(now))
+		}
+	}
+	return resetIn
+}
+
+func (l *RateLimiter) resetExpired(now time.Time) {
+	shortTermStart := now.Truncate(15 * time.Minute)
+	if !l.shortTerm.start.Equal(shortTermStart) {
+		l.shortTerm.start = shortTermStart
+		l.shortTerm.usage = 0
+	}
+	utc := now.UTC()
+	dailyStart := time.Date(utc.Year(), utc.Month(), utc.Day(), 0, 0, 0, 0, time.UTC)
+	if !l.daily.start.Equal(dailyStart) {
+		l.daily.start = dailyStart
+		l.daily.usage = 0
+	}
+}
+
+func (l *RateLimiter) windowEnd(w *rateLimitWindow) time.Time {
+	if w == &l.daily {
+		return w.start.AddDate(0, 0, 1)
+	}
+	return w.start.Add(15 * time.Minute)
+}
+
+// parseRateLimitHeader parses "<15-minute>,<daily>" header value
+func parseRateLimitHeader(value string) ([2]int, bool) {
+	result := [2]int{}
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return result, false
+	}
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return result, false
+		}
+		result[i] = v
+	}
+	return result, true
+}
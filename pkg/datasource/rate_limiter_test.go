@@ -0,0 +1,148 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestRateLimiter(now *time.Time) *RateLimiter {
+	l := NewRateLimiter()
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+// allowed returns number of requests allowed before limiter rejects one
+func allowed(t *testing.T, l *RateLimiter, ctx context.Context, limit int) int {
+	t.Helper()
+	for i := 0; i < limit; i++ {
+		if err := l.Allow(ctx); err != nil {
+			return i
+		}
+	}
+	return limit
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		header   http.Header
+		expected int
+	}{
+		{
+			name:     "interactive default limit",
+			ctx:      context.Background(),
+			expected: DefaultShortTermRateLimit,
+		},
+		{
+			name:     "background leaves interactive reserve",
+			ctx:      WithBackgroundPriority(context.Background()),
+			expected: 80,
+		},
+		{
+			name:     "limits and usage from headers",
+			ctx:      context.Background(),
+			header:   http.Header{"X-Ratelimit-Limit": {"200,2000"}, "X-Ratelimit-Usage": {"150,300"}},
+			expected: 50,
+		},
+		{
+			name: "read limits preferred",
+			ctx:  context.Background(),
+			header: http.Header{
+				"X-Ratelimit-Limit":     {"200,2000"},
+				"X-Ratelimit-Usage":     {"10,10"},
+				"X-Readratelimit-Limit": {"100,1000"},
+				"X-Readratelimit-Usage": {"90,100"},
+			},
+			expected: 10,
+		},
+		{
+			name:     "daily limit",
+			ctx:      context.Background(),
+			header:   http.Header{"X-Ratelimit-Limit": {"100,1000"}, "X-Ratelimit-Usage": {"0,995"}},
+			expected: 5,
+		},
+		{
+			name:     "invalid headers ignored",
+			ctx:      context.Background(),
+			header:   http.Header{"X-Ratelimit-Limit": {"100"}, "X-Ratelimit-Usage": {"a,b"}},
+			expected: DefaultShortTermRateLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC)
+			l := newTestRateLimiter(&now)
+			if tt.header != nil {
+				l.Update(tt.header, http.StatusOK)
+			}
+			if n := allowed(t, l, tt.ctx, 1000); n != tt.expected {
+				t.Errorf("expected %d allowed requests, got %d", tt.expected, n)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWindows(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC)
+	l := newTestRateLimiter(&now)
+	ctx := context.Background()
+
+	allowed(t, l, ctx, DefaultShortTermRateLimit)
+	err := l.Allow(ctx)
+	rateLimitErr := &RateLimitError{}
+	if !errors.As(err, &rateLimitErr) || !errors.Is(err, ErrRateLimitExceeded) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if rateLimitErr.Window != RateLimitWindowShortTerm {
+		t.Errorf("expected %s window, got %s", RateLimitWindowShortTerm, rateLimitErr.Window)
+	}
+	if expected := time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC); !rateLimitErr.ResetAt.Equal(expected) {
+		t.Errorf("expected reset at %s, got %s", expected, rateLimitErr.ResetAt)
+	}
+
+	// 15-minute window is reset at natural 15-minute intervals
+	now = time.Date(2024, 5, 1, 10, 14, 59, 0, time.UTC)
+	if l.Allow(ctx) == nil {
+		t.Fatal("expected request rejected before window reset")
+	}
+	now = time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC)
+	if err := l.Allow(ctx); err != nil {
+		t.Fatalf("expected request allowed after window reset, got %v", err)
+	}
+
+	// Daily window is reset at midnight UTC
+	l.Update(http.Header{"X-Ratelimit-Limit": {"100,1000"}, "X-Ratelimit-Usage": {"0,1000"}}, http.StatusOK)
+	err = l.Allow(ctx)
+	if !errors.As(err, &rateLimitErr) || rateLimitErr.Window != RateLimitWindowDaily {
+		t.Fatalf("expected daily rate limit error, got %v", err)
+	}
+	if expected := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC); !rateLimitErr.ResetAt.Equal(expected) {
+		t.Errorf("expected reset at %s, got %s", expected, rateLimitErr.ResetAt)
+	}
+	now = time.Date(2024, 5, 2, 0, 0, 1, 0, time.UTC)
+	if err := l.Allow(ctx); err != nil {
+		t.Fatalf("expected request allowed next day, got %v", err)
+	}
+}
+
+func TestRateLimiterTooManyRequests(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC)
+	l := newTestRateLimiter(&now)
+	ctx := context.Background()
+
+	// Limit might be exceeded by other clients of the application, so 429 blocks requests without headers
+	l.Update(http.Header{}, http.StatusTooManyRequests)
+	if l.Allow(ctx) == nil {
+		t.Fatal("expected request rejected after 429")
+	}
+
+	now = now.Add(15 * time.Minute)
+	if err := l.Allow(ctx); err != nil {
+		t.Fatalf("expected request allowed after window reset, got %v", err)
+	}
+}
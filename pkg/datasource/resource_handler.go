@@ -2,8 +2,11 @@ package datasource
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
 
 	simplejson "github.com/bitly/go-simplejson"
@@ -42,7 +45,7 @@ func (ds *StravaDatasourcePlugin) StravaAuthHandler(rw http.ResponseWriter, req
 	err = json.Unmarshal(body, &reqData)
 	if err != nil {
 		ds.logger.Error("Cannot unmarshal request", "error", err.Error())
-		writeError(rw, http.StatusInternalServerError, err)
+		writeError(rw, http.StatusBadRequest, err)
 		return
 	}
 
@@ -57,7 +60,7 @@ func (ds *StravaDatasourcePlugin) StravaAuthHandler(rw http.ResponseWriter, req
 	result, err := dsInstance.StravaAuthQuery(req.Context(), &reqData)
 	if err != nil {
 		ds.logger.Error("Strava API request error", "error", err)
-		writeError(rw, http.StatusInternalServerError, err)
+		writeError(rw, errorStatusCode(err), err)
 		return
 	}
 
@@ -123,6 +126,197 @@ func (ds *StravaDatasourcePlugin) ResetCacheHandler(rw http.ResponseWriter, req
 	}
 }
 
+// CacheEntriesHandler returns API responses kept in cache
+func (ds *StravaDatasourcePlugin) CacheEntriesHandler(rw http.ResponseWriter, req *http.Request) {
+	pluginCxt := backend.PluginConfigFromContext(req.Context())
+	dsInstance, err := ds.getDSInstance(req.Context(), pluginCxt)
+	if err != nil {
+		ds.logger.Error("Error loading datasource", "error", err)
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeApiResponse(rw, &StravaApiResourceResponse{Result: dsInstance.cache.Entries()})
+}
+
+// CacheStatsHandler returns cache usage statistics
+func (ds *StravaDatasourcePlugin) CacheStatsHandler(rw http.ResponseWriter, req *http.Request) {
+	pluginCxt := backend.PluginConfigFromContext(req.Context())
+	dsInstance, err := ds.getDSInstance(req.Context(), pluginCxt)
+	if err != nil {
+		ds.logger.Error("Error loading datasource", "error", err)
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeApiResponse(rw, &StravaApiResourceResponse{Result: dsInstance.cache.Stats()})
+}
+
+// CacheInvalidateHandler removes cached responses by endpoint pattern or activity id
+func (ds *StravaDatasourcePlugin) CacheInvalidateHandler(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil || len(body) == 0 {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	var invalidateReq CacheInvalidateRequest
+	err = json.Unmarshal(body, &invalidateReq)
+	if err != nil {
+		ds.logger.Error("Cannot unmarshal request", "error", err.Error())
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	if invalidateReq.Endpoint == "" && invalidateReq.ActivityId == 0 {
+		writeError(rw, http.StatusBadRequest, errors.New("endpoint pattern or activity id is required"))
+		return
+	}
+
+	pluginCxt := backend.PluginConfigFromContext(req.Context())
+	dsInstance, err := ds.getDSInstance(req.Context(), pluginCxt)
+	if err != nil {
+		ds.logger.Error("Error loading datasource", "error", err)
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	invalidated := 0
+	if invalidateReq.Endpoint != "" {
+		pattern, err := regexp.Compile(invalidateReq.Endpoint)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, fmt.Errorf("invalid endpoint pattern: %w", err))
+			return
+		}
+		invalidated += dsInstance.InvalidateCache(pattern)
+	}
+	if invalidateReq.ActivityId != 0 {
+		invalidated += dsInstance.InvalidateActivityCache(invalidateReq.ActivityId)
+	}
+
+	writeApiResponse(rw, &StravaApiResourceResponse{Result: map[string]interface{}{
+		"message":     "Cache invalidated",
+		"invalidated": invalidated,
+	}})
+}
+
+// PrefetchStatusHandler returns background sync progress and status of the prefetch tasks
+func (ds *StravaDatasourcePlugin) PrefetchStatusHandler(rw http.ResponseWriter, req *http.Request) {
+	pluginCxt := backend.PluginConfigFromContext(req.Context())
+	dsInstance, err := ds.getDSInstance(req.Context(), pluginCxt)
+	if err != nil {
+		ds.logger.Error("Error loading datasource", "error", err)
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := SyncStatus{Enabled: false}
+	if dsInstance.prefetcher != nil {
+		status = dsInstance.prefetcher.Status()
+	}
+	if dsInstance.activityStore != nil {
+		storeState, err := dsInstance.activityStore.State()
+		if err != nil {
+			ds.logger.Warn("Error reading activity store", "error", err)
+		} else {
+			status.ActivityStore = &storeState
+		}
+	}
+	writeApiResponse(rw, &StravaApiResourceResponse{Result: status})
+}
+
+// WebhookHandler receives Strava push subscription events. GET request validates subscription by echoing
+// hub.challenge if hub.verify_token matches the data source setting, POST request delivers event, events of
+// other subscriptions are rejected.
+func (ds *StravaDatasourcePlugin) WebhookHandler(rw http.ResponseWriter, req *http.Request) {
+	pluginCxt := backend.PluginConfigFromContext(req.Context())
+	dsInstance, err := ds.getDSInstance(req.Context(), pluginCxt)
+	if err != nil {
+		ds.logger.Error("Error loading datasource", "error", err)
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		query := req.URL.Query()
+		if query.Get("hub.mode") != "subscribe" || !dsInstance.VerifyWebhookToken(query.Get("hub.verify_token")) {
+			writeError(rw, http.StatusForbidden, errors.New("invalid webhook verify token"))
+			return
+		}
+		resultJson, err := json.Marshal(map[string]string{"hub.challenge": query.Get("hub.challenge")})
+		if err != nil {
+			writeError(rw, http.StatusInternalServerError, err)
+			return
+		}
+		writeResponse(rw, resultJson)
+	case http.MethodPost:
+		body, err := io.ReadAll(req.Body)
+		defer req.Body.Close()
+		if err != nil || len(body) == 0 {
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+
+		var event WebhookEvent
+		err = json.Unmarshal(body, &event)
+		if err != nil {
+			ds.logger.Error("Cannot unmarshal webhook event", "error", err.Error())
+			writeError(rw, http.StatusBadRequest, err)
+			return
+		}
+		if !dsInstance.VerifyWebhookSubscription(req.Context(), event.SubscriptionId) {
+			ds.logger.Warn("Webhook event of unknown subscription rejected", "subscription", event.SubscriptionId)
+			writeError(rw, http.StatusForbidden, errors.New("unknown webhook subscription"))
+			return
+		}
+		ds.logger.Debug("Webhook event received", "object", event.ObjectType, "id", event.ObjectId, "aspect", event.AspectType)
+		dsInstance.HandleWebhookEvent(&event)
+		rw.WriteHeader(http.StatusOK)
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// AthletesHandler returns athletes authorized in the data source
+func (ds *StravaDatasourcePlugin) AthletesHandler(rw http.ResponseWriter, req *http.Request) {
+	pluginCxt := backend.PluginConfigFromContext(req.Context())
+	dsInstance, err := ds.getDSInstance(req.Context(), pluginCxt)
+	if err != nil {
+		ds.logger.Error("Error loading datasource", "error", err)
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	athletes, err := dsInstance.GetAthletes(req.Context())
+	if err != nil {
+		ds.logger.Error("Error loading athletes", "error", err)
+		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	result := make([]AthleteDTO, 0)
+	for _, athleteId := range append([]int64{DefaultAthleteId}, athletes...) {
+		athlete, err := dsInstance.GetAthlete(req.Context(), athleteId, "")
+		if err != nil {
+			ds.logger.Warn("Error loading athlete", "athleteId", athleteId, "error", err)
+			continue
+		}
+		result = append(result, AthleteDTO{
+			Id:        athleteId,
+			AthleteId: athlete.Id,
+			FirstName: athlete.FirstName,
+			LastName:  athlete.LastName,
+		})
+	}
+
+	writeApiResponse(rw, &StravaApiResourceResponse{Result: result})
+}
+
 func (ds *StravaDatasourcePlugin) StravaAPIHandler(rw http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
 		return
@@ -139,7 +333,7 @@ func (ds *StravaDatasourcePlugin) StravaAPIHandler(rw http.ResponseWriter, req *
 	err = json.Unmarshal(body, &apiReq)
 	if err != nil {
 		ds.logger.Error("Cannot unmarshal request", "error", err.Error())
-		writeError(rw, http.StatusInternalServerError, err)
+		writeError(rw, http.StatusBadRequest, err)
 		return
 	}
 
@@ -153,23 +347,13 @@ func (ds *StravaDatasourcePlugin) StravaAPIHandler(rw http.ResponseWriter, req *
 
 	oauthPassThru := isOAuthPassThruEnabled(dsInstance)
 	if oauthPassThru {
-		accessToken := ""
-		if len(req.Header["Authorization"]) > 0 {
-			authorizationStr := req.Header["Authorization"][0]
-			values := strings.Split(authorizationStr, " ")
-			if len(values) > 1 {
-				accessToken = values[1]
-			}
-		}
-		apiReq.AccessToken = accessToken
+		apiReq.AccessToken = getBearerToken(req.Header.Get("Authorization"))
 	}
 
-	requestHash := HashByte(body)
-	stravaApiQueryFn := dsInstance.StravaAPIQueryWithCache(requestHash)
-	result, err := stravaApiQueryFn(req.Context(), &apiReq)
+	result, err := dsInstance.StravaAPIQueryWithCache(req.Context(), &apiReq)
 	if err != nil {
 		ds.logger.Error("Strava API request error", "error", err)
-		writeError(rw, http.StatusInternalServerError, err)
+		writeError(rw, errorStatusCode(err), err)
 		return
 	}
 
@@ -180,6 +364,11 @@ func writeApiResponse(rw http.ResponseWriter, result *StravaApiResourceResponse)
 	resultJson, err := json.Marshal(*result)
 	if err != nil {
 		writeError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	if result.Stale {
+		rw.Header().Set(StaleResponseHeader, "true")
 	}
 
 	writeResponse(rw, resultJson)
@@ -189,6 +378,7 @@ func writeAuthResponse(rw http.ResponseWriter, result *StravaAuthResourceRespons
 	resultJson, err := json.Marshal(*result)
 	if err != nil {
 		writeError(rw, http.StatusInternalServerError, err)
+		return
 	}
 
 	writeResponse(rw, resultJson)
@@ -206,8 +396,18 @@ func writeResponse(rw http.ResponseWriter, resultJson []byte) {
 func writeError(rw http.ResponseWriter, statusCode int, err error) {
 	data := make(map[string]interface{})
 
-	data["error"] = "Internal Server Error"
-	data["message"] = err.Error()
+	data["error"] = http.StatusText(statusCode)
+	if err != nil {
+		data["message"] = err.Error()
+	}
+
+	var apiErr *StravaAPIError
+	if errors.As(err, &apiErr) {
+		data["stravaStatus"] = apiErr.StatusCode
+		if len(apiErr.Errors) > 0 {
+			data["errors"] = apiErr.Errors
+		}
+	}
 
 	var b []byte
 	if b, err = json.Marshal(data); err != nil {
@@ -216,7 +416,7 @@ func writeError(rw http.ResponseWriter, statusCode int, err error) {
 	}
 
 	rw.Header().Add("Content-Type", "application/json")
-	rw.WriteHeader(http.StatusInternalServerError)
+	rw.WriteHeader(statusCode)
 	_, err = rw.Write(b)
 	if err != nil {
 		log.DefaultLogger.Warn("Error writing response")
@@ -231,3 +431,12 @@ func isOAuthPassThruEnabled(dsInstance *StravaDatasourceInstance) bool {
 	}
 	return jsonData.Get("oauthPassThru").MustBool()
 }
+
+// getBearerToken extracts token from the Authorization header value
+func getBearerToken(authorization string) string {
+	values := strings.Split(authorization, " ")
+	if len(values) > 1 {
+		return values[1]
+	}
+	return ""
+}
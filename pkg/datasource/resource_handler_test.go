@@ -0,0 +1,46 @@
+package datasource
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func TestResourceHandlersMalformedBody(t *testing.T) {
+	ds := &StravaDatasourcePlugin{logger: log.DefaultLogger}
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{name: "api empty body", handler: ds.StravaAPIHandler, body: ""},
+		{name: "api malformed body", handler: ds.StravaAPIHandler, body: `{"endpoint":`},
+		{name: "auth malformed body", handler: ds.StravaAuthHandler, body: `{"authCode":`},
+		{name: "invalidate malformed body", handler: ds.CacheInvalidateHandler, body: `{"endpoint":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+			}
+		})
+	}
+}
+
+func TestWriteApiResponseMarshalError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeApiResponse(rec, &StravaApiResourceResponse{Result: make(chan int)})
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	if body := rec.Body.String(); strings.Count(body, "{") != 1 {
+		t.Errorf("expected single error response, got %s", body)
+	}
+}
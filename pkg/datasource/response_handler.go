@@ -0,0 +1,403 @@
+package datasource
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/strava-datasource/pkg/units"
+)
+
+// Response handler converts Strava API responses into the data frames, the same way as
+// responseHandler.ts does it in the frontend.
+
+type datapoint struct {
+	value *float64
+	ts    int64
+}
+
+func transformActivitiesToTimeseries(activities []StravaActivity, query QueryModel, mp units.MeasurementPreference) *data.Frame {
+	datapoints := make([]datapoint, 0)
+	for _, activity := range activities {
+		statValue := getActivityStat(&activity, query.ActivityStat, mp)
+		datapoints = append(datapoints, datapoint{statValue, activity.StartDate.UnixMilli()})
+	}
+	sort.SliceStable(datapoints, func(i, j int) bool {
+		return datapoints[i].ts < datapoints[j].ts
+	})
+
+	if query.Interval != IntervalNo {
+		var aggInterval time.Duration
+		if query.Interval == "" || query.Interval == IntervalAuto {
+			aggInterval = getAggregationInterval(query.TimeRange.Duration())
+		} else {
+			aggInterval = getAggregationIntervalFromQuery(query.Interval)
+		}
+
+		if aggInterval >= Interval4w {
+			datapoints = groupByMonthSum(datapoints, query.TimeRange)
+		} else if aggInterval == Interval1w {
+			datapoints = groupByWeekSum(datapoints, query.TimeRange)
+		} else {
+			datapoints = groupBySum(datapoints, query.TimeRange, aggInterval)
+		}
+	}
+
+	timeValues := make([]time.Time, 0, len(datapoints))
+	statValues := make([]*float64, 0, len(datapoints))
+	for _, dp := range datapoints {
+		timeValues = append(timeValues, time.UnixMilli(dp.ts))
+		statValues = append(statValues, dp.value)
+	}
+
+	valueField := data.NewField(data.TimeSeriesValueFieldName, nil, statValues)
+	valueField.Config = &data.FieldConfig{
+		Unit: getStatUnit(query.ActivityStat, mp),
+	}
+
+	alias := query.ActivityStat
+	if query.ActivityType != "" {
+		alias = query.ActivityType + "_" + alias
+	}
+
+	frame := data.NewFrame(alias,
+		data.NewField(data.TimeSeriesTimeFieldName, nil, timeValues),
+		valueField,
+	)
+	frame.RefID = query.RefID
+	return frame
+}
+
+func getActivityStat(activity *StravaActivity, activityStat string, mp units.MeasurementPreference) *float64 {
+	var value float64
+	switch activityStat {
+	case ActivityStatDistance:
+		value = mp.Distance(activity.Distance)
+	case ActivityStatElevationGain:
+		value = mp.Length(activity.TotalElevationGain)
+	default:
+		return activity.Stat(activityStat)
+	}
+	return &value
+}
+
+func getStatUnit(activityStat string, mp units.MeasurementPreference) string {
+	switch activityStat {
+	case ActivityStatDistance:
+		return mp.DistanceUnit()
+	case ActivityStatElevationGain:
+		return mp.LengthUnit()
+	case ActivityStatElapsedTime, ActivityStatMovingTime:
+		return units.UnitDuration
+	case ActivityStatAveragePower:
+		return units.UnitWatt
+	default:
+		return units.UnitNone
+	}
+}
+
+func groupBySum(datapoints []datapoint, timeRange backend.TimeRange, interval time.Duration) []datapoint {
+	intervalMs := interval.Milliseconds()
+	intervalFn := func(ts int64) int64 {
+		return ts / intervalMs * intervalMs
+	}
+	nextIntervalFn := func(ts int64) int64 {
+		return ts + intervalMs
+	}
+	return groupByTime(datapoints, timeRange, intervalFn, nextIntervalFn, aggSum)
+}
+
+func groupByWeekSum(datapoints []datapoint, timeRange backend.TimeRange) []datapoint {
+	return groupByTime(datapoints, timeRange, getClosestWeek, getNextWeek, aggSum)
+}
+
+func groupByMonthSum(datapoints []datapoint, timeRange backend.TimeRange) []datapoint {
+	return groupByTime(datapoints, timeRange, getClosestMonth, getNextMonth, aggSum)
+}
+
+// groupByTime aggregates sorted datapoints into the time frames, defined by intervalFn, and fills
+// empty frames within the time range by nulls.
+func groupByTime(
+	datapoints []datapoint,
+	timeRange backend.TimeRange,
+	intervalFn func(int64) int64,
+	nextIntervalFn func(int64) int64,
+	groupByFn func([]*float64) *float64,
+) []datapoint {
+	if len(datapoints) == 0 {
+		return []datapoint{}
+	}
+
+	timeFrom := timeRange.From.Unix() * 1000
+	timeTo := timeRange.To.Unix() * 1000
+	groupedSeries := make([]datapoint, 0)
+	frameValues := make([]*float64, 0)
+	frameTs := intervalFn(timeFrom)
+
+	for _, point := range datapoints {
+		pointFrameTs := intervalFn(point.ts)
+		if pointFrameTs == frameTs {
+			frameValues = append(frameValues, point.value)
+		} else if pointFrameTs > frameTs {
+			groupedSeries = append(groupedSeries, datapoint{groupByFn(frameValues), frameTs})
+
+			// Move frame window to next non-empty interval and fill empty by null
+			frameTs = nextIntervalFn(frameTs)
+			for frameTs < pointFrameTs {
+				groupedSeries = append(groupedSeries, datapoint{nil, frameTs})
+				frameTs = nextIntervalFn(frameTs)
+			}
+			frameValues = []*float64{point.value}
+		}
+	}
+
+	groupedSeries = append(groupedSeries, datapoint{groupByFn(frameValues), frameTs})
+
+	// Move frame window to end of time range and fill empty by null
+	frameTs = nextIntervalFn(frameTs)
+	for frameTs < timeTo {
+		groupedSeries = append(groupedSeries, datapoint{nil, frameTs})
+		frameTs = nextIntervalFn(frameTs)
+	}
+
+	return groupedSeries
+}
+
+func aggSum(values []*float64) *float64 {
+	var sum *float64
+	for _, v := range values {
+		if v == nil {
+			continue
+		}
+		if sum == nil {
+			sum = new(float64)
+		}
+		*sum += *v
+	}
+	return sum
+}
+
+func getClosestWeek(ts int64) int64 {
+	// The first Monday after the Unix Epoch begins on Jan 5, 1970, 00:00.
+	// This is a UNIX timestamp of 96 hours or 345600000 ms
+	const firstMondayMs = 345600000
+	weekMs := Interval1w.Milliseconds()
+	return (ts-firstMondayMs)/weekMs*weekMs + firstMondayMs
+}
+
+func getNextWeek(ts int64) int64 {
+	return ts + Interval1w.Milliseconds()
+}
+
+func getClosestMonth(ts int64) int64 {
+	t := time.UnixMilli(ts).UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+}
+
+func getNextMonth(ts int64) int64 {
+	return time.UnixMilli(ts).UTC().AddDate(0, 1, 0).UnixMilli()
+}
+
+func transformActivitiesToTable(activities []StravaActivity, query QueryModel, mp units.MeasurementPreference) *data.Frame {
+	timeValues := make([]time.Time, 0)
+	names := make([]string, 0)
+	distances := make([]float64, 0)
+	movingTimes := make([]int64, 0)
+	elapsedTimes := make([]int64, 0)
+	heartRates := make([]*float64, 0)
+	elevationGains := make([]float64, 0)
+	kilojoules := make([]*float64, 0)
+	types := make([]string, 0)
+	ids := make([]string, 0)
+	timeFrom := make([]int64, 0)
+	timeTo := make([]int64, 0)
+
+	for _, activity := range activities {
+		timeValues = append(timeValues, activity.StartDate)
+		names = append(names, activity.Name)
+		distances = append(distances, mp.Distance(activity.Distance))
+		movingTimes = append(movingTimes, activity.MovingTime)
+		elapsedTimes = append(elapsedTimes, activity.ElapsedTime)
+		heartRates = append(heartRates, activity.Stat("average_heartrate"))
+		elevationGains = append(elevationGains, mp.Length(activity.TotalElevationGain))
+		kilojoules = append(kilojoules, activity.Stat("kilojoules"))
+		types = append(types, activity.SportType)
+		ids = append(ids, fmt.Sprintf("%d", activity.Id))
+		timeFrom = append(timeFrom, activity.StartDate.Unix()*1000)
+		timeTo = append(timeTo, (activity.StartDate.Unix()+activity.ElapsedTime)*1000)
+	}
+
+	frame := data.NewFrame("",
+		data.NewField("time", nil, timeValues),
+		data.NewField("name", nil, names),
+		data.NewField("distance", nil, distances).SetConfig(&data.FieldConfig{Unit: mp.DistanceUnit()}),
+		data.NewField("moving time", nil, movingTimes).SetConfig(&data.FieldConfig{Unit: units.UnitDuration}),
+		data.NewField("elapsed time", nil, elapsedTimes).SetConfig(&data.FieldConfig{Unit: units.UnitDuration}),
+		data.NewField("heart rate", nil, heartRates).SetConfig(&data.FieldConfig{Unit: units.UnitNone, Decimals: decimals(0)}),
+		data.NewField("elevation gain", nil, elevationGains).SetConfig(&data.FieldConfig{Unit: mp.LengthUnit(), Decimals: decimals(0)}),
+		data.NewField("kilojoules", nil, kilojoules).SetConfig(&data.FieldConfig{Unit: units.UnitJoule}),
+		data.NewField("type", nil, types),
+		data.NewField("id", nil, ids).SetConfig(hiddenFieldConfig()),
+		data.NewField("time_from", nil, timeFrom).SetConfig(hiddenTimeFieldConfig()),
+		data.NewField("time_to", nil, timeTo).SetConfig(hiddenTimeFieldConfig()),
+	)
+
+	for _, stat := range query.ExtendedStats {
+		frame.Fields = append(frame.Fields, buildExtendedStatField(activities, stat))
+	}
+
+	frame.RefID = query.RefID
+	return frame
+}
+
+// buildExtendedStatField creates numeric field if all the stat values are numbers and string field otherwise
+func buildExtendedStatField(activities []StravaActivity, stat string) *data.Field {
+	numericValues := make([]*float64, 0, len(activities))
+	stringValues := make([]*string, 0, len(activities))
+	isNumeric := true
+
+	for _, activity := range activities {
+		rawValue, ok := activity.Field(stat)
+		if !ok || isEmptyJSONValue(rawValue) {
+			numericValues = append(numericValues, nil)
+			stringValues = append(stringValues, nil)
+			continue
+		}
+
+		numericValue := activity.Stat(stat)
+		if numericValue == nil {
+			isNumeric = false
+		}
+		numericValues = append(numericValues, numericValue)
+
+		var stringValue string
+		if err := json.Unmarshal(rawValue, &stringValue); err != nil {
+			stringValue = string(rawValue)
+		}
+		stringValues = append(stringValues, &stringValue)
+	}
+
+	if isNumeric {
+		return data.NewField(stat, nil, numericValues)
+	}
+	return data.NewField(stat, nil, stringValues)
+}
+
+// isEmptyJSONValue reports whether value is falsy in terms of JavaScript, so extended stats
+// behave the same way as in the frontend.
+func isEmptyJSONValue(value json.RawMessage) bool {
+	switch string(value) {
+	case "null", "false", "0", `""`:
+		return true
+	default:
+		return false
+	}
+}
+
+func transformActivitiesToGeomap(activities []StravaActivity, query QueryModel, mp units.MeasurementPreference) *data.Frame {
+	names := make([]string, 0)
+	latitudes := make([]float64, 0)
+	longitudes := make([]float64, 0)
+	values := make([]*float64, 0)
+	timeValues := make([]time.Time, 0)
+	ids := make([]string, 0)
+	timeFrom := make([]int64, 0)
+	timeTo := make([]int64, 0)
+
+	for _, activity := range activities {
+		var latitude, longitude float64
+		middlePoint, ok := getActivityMiddlePoint(&activity)
+		if ok {
+			latitude, longitude = middlePoint[0], middlePoint[1]
+		} else if len(activity.StartLatLng) == 2 {
+			latitude, longitude = activity.StartLatLng[0], activity.StartLatLng[1]
+		}
+		if latitude == 0 || longitude == 0 {
+			continue
+		}
+
+		names = append(names, activity.Name)
+		latitudes = append(latitudes, latitude)
+		longitudes = append(longitudes, longitude)
+		values = append(values, getActivityStat(&activity, query.ActivityStat, mp))
+		timeValues = append(timeValues, activity.StartDate)
+		ids = append(ids, fmt.Sprintf("%d", activity.Id))
+		timeFrom = append(timeFrom, activity.StartDate.Unix()*1000)
+		timeTo = append(timeTo, (activity.StartDate.Unix()+activity.ElapsedTime)*1000)
+	}
+
+	frame := data.NewFrame("activities",
+		data.NewField("name", nil, names),
+		data.NewField("latitude", nil, latitudes),
+		data.NewField("longitude", nil, longitudes),
+		data.NewField("value", nil, values).SetConfig(&data.FieldConfig{
+			Unit: getStatUnit(query.ActivityStat, mp),
+		}),
+		data.NewField("time", nil, timeValues),
+		data.NewField("id", nil, ids).SetConfig(hiddenFieldConfig()),
+		data.NewField("time_from", nil, timeFrom).SetConfig(hiddenTimeFieldConfig()),
+		data.NewField("time_to", nil, timeTo).SetConfig(hiddenTimeFieldConfig()),
+	)
+	frame.RefID = query.RefID
+	return frame
+}
+
+func transformActivitiesToHeatmap(activities []StravaActivity, query QueryModel) *data.Frame {
+	latitudes := make([]float64, 0)
+	longitudes := make([]float64, 0)
+	values := make([]float64, 0)
+
+	for _, activity := range activities {
+		summaryPolyline := activity.Map.SummaryPolyline
+		if summaryPolyline == "" {
+			continue
+		}
+		for _, point := range decodePolyline(summaryPolyline) {
+			latitudes = append(latitudes, point[0])
+			longitudes = append(longitudes, point[1])
+			values = append(values, 1)
+		}
+	}
+
+	frame := data.NewFrame("heatmap",
+		data.NewField("latitude", nil, latitudes),
+		data.NewField("longitude", nil, longitudes),
+		data.NewField("value", nil, values),
+	)
+	frame.RefID = query.RefID
+	return frame
+}
+
+func getActivityMiddlePoint(activity *StravaActivity) ([2]float64, bool) {
+	summaryPolyline := activity.Map.SummaryPolyline
+	if summaryPolyline == "" {
+		return [2]float64{}, false
+	}
+
+	points := decodePolyline(summaryPolyline)
+	if len(points) == 0 {
+		return [2]float64{}, false
+	}
+	return points[len(points)/2], true
+}
+
+func decimals(value uint16) *uint16 {
+	return &value
+}
+
+// hiddenFieldConfig returns config for the service fields, which are not displayed in the table
+func hiddenFieldConfig() *data.FieldConfig {
+	return &data.FieldConfig{
+		Unit:   units.UnitNone,
+		Custom: map[string]interface{}{"hidden": true},
+	}
+}
+
+func hiddenTimeFieldConfig() *data.FieldConfig {
+	config := hiddenFieldConfig()
+	config.Decimals = decimals(0)
+	return config
+}
@@ -0,0 +1,84 @@
+package datasource
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry policy for idempotent Strava API requests failed with 429 or 5xx status
+const (
+	MaxRetries     = 3
+	RetryBaseDelay = 500 * time.Millisecond
+	RetryMaxDelay  = 30 * time.Second
+)
+
+// MaxErrorBodyLength limits size of non-JSON error response included into the error message
+const MaxErrorBodyLength = 256
+
+// isRetryableStatus returns true if request could succeed if repeated later
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// retryDelay returns delay before the next attempt. Retry-After header is honored if present, otherwise
+// exponential backoff with jitter is used.
+func retryDelay(attempt int, header http.Header) time.Duration {
+	if delay, ok := parseRetryAfter(header.Get("Retry-After")); ok {
+		return min(delay, RetryMaxDelay)
+	}
+
+	backoff := min(RetryBaseDelay<<attempt, RetryMaxDelay)
+	// Jitter in [backoff/2, backoff) to spread retries of concurrent requests
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+}
+
+// rateLimitRetryDelay returns delay before retrying request rejected with 429 status: Retry-After value or time
+// until the rate limit window is reset. It returns false if limit isn't reset within RetryMaxDelay.
+func rateLimitRetryDelay(header http.Header, resetIn time.Duration) (time.Duration, bool) {
+	delay, ok := parseRetryAfter(header.Get("Retry-After"))
+	if !ok {
+		delay = resetIn
+	}
+	return delay, delay <= RetryMaxDelay
+}
+
+// parseRetryAfter reads Retry-After value given either in seconds or as HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// waitForRetry sleeps for the given delay. It returns false without waiting if the context deadline
+// comes before the delay ends, or if the context is done while waiting.
+func waitForRetry(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	return s[:length] + "..."
+}
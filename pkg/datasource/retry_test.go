@@ -0,0 +1,215 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{name: "empty", value: "", ok: false},
+		{name: "seconds", value: "5", delay: 5 * time.Second, ok: true},
+		{name: "zero", value: "0", delay: 0, ok: true},
+		{name: "negative", value: "-1", ok: false},
+		{name: "invalid", value: "soon", ok: false},
+		{name: "date in the past", value: "Wed, 21 Oct 2015 07:28:00 GMT", delay: 0, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tt.value)
+			if ok != tt.ok || delay != tt.delay {
+				t.Errorf("expected %v, %v, got %v, %v", tt.delay, tt.ok, delay, ok)
+			}
+		})
+	}
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	delay, ok := parseRetryAfter(date)
+	if !ok || delay <= 50*time.Second || delay > time.Minute {
+		t.Errorf("expected about a minute for date in the future, got %v, %v", delay, ok)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		header  http.Header
+		min     time.Duration
+		max     time.Duration
+	}{
+		{name: "first attempt", attempt: 0, min: RetryBaseDelay / 2, max: RetryBaseDelay},
+		{name: "exponential backoff", attempt: 3, min: 4 * RetryBaseDelay, max: 8 * RetryBaseDelay},
+		{name: "max delay", attempt: 20, min: RetryMaxDelay / 2, max: RetryMaxDelay},
+		{name: "retry after", attempt: 0, header: http.Header{"Retry-After": {"3"}}, min: 3 * time.Second, max: 3 * time.Second},
+		{name: "retry after limited", attempt: 0, header: http.Header{"Retry-After": {"600"}}, min: RetryMaxDelay, max: RetryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			for i := 0; i < 100; i++ {
+				delay := retryDelay(tt.attempt, header)
+				if delay < tt.min || delay > tt.max || (tt.min != tt.max && delay == tt.max) {
+					t.Fatalf("expected delay in [%v, %v), got %v", tt.min, tt.max, delay)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  http.Header
+		resetIn time.Duration
+		delay   time.Duration
+		ok      bool
+	}{
+		{name: "retry after", header: http.Header{"Retry-After": {"2"}}, resetIn: 10 * time.Minute, delay: 2 * time.Second, ok: true},
+		{name: "window reset", header: http.Header{}, resetIn: 5 * time.Second, delay: 5 * time.Second, ok: true},
+		{name: "window reset too late", header: http.Header{}, resetIn: 10 * time.Minute, delay: 10 * time.Minute, ok: false},
+		{name: "retry after too late", header: http.Header{"Retry-After": {"120"}}, delay: 2 * time.Minute, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := rateLimitRetryDelay(tt.header, tt.resetIn)
+			if ok != tt.ok || delay != tt.delay {
+				t.Errorf("expected %v, %v, got %v, %v", tt.delay, tt.ok, delay, ok)
+			}
+		})
+	}
+}
+
+func newTestInstance(client *http.Client) *StravaDatasourceInstance {
+	return &StravaDatasourceInstance{
+		httpClient:  client,
+		rateLimiter: NewRateLimiter(),
+		logger:      log.DefaultLogger,
+	}
+}
+
+func TestMakeHTTPRequestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		header     http.Header
+		method     string
+		resetIn    time.Duration
+		requests   int32
+		wantStatus int
+	}{
+		{
+			name:     "429 with retry after",
+			statuses: []int{http.StatusTooManyRequests, http.StatusOK},
+			header:   http.Header{"Retry-After": {"0"}},
+			requests: 2,
+		},
+		{
+			name:     "429 until window reset",
+			statuses: []int{http.StatusTooManyRequests, http.StatusOK},
+			resetIn:  100 * time.Millisecond,
+			requests: 2,
+		},
+		{
+			name:       "429 with window reset too late",
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			resetIn:    10 * time.Minute,
+			requests:   1,
+			wantStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:     "5xx",
+			statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK},
+			requests: 3,
+		},
+		{
+			name:       "not retryable",
+			statuses:   []int{http.StatusNotFound, http.StatusOK},
+			requests:   1,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "not idempotent",
+			statuses:   []int{http.StatusBadGateway, http.StatusOK},
+			method:     http.MethodPost,
+			requests:   1,
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "max retries",
+			statuses:   []int{500, 500, 500, 500, 500},
+			requests:   MaxRetries + 1,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := requests.Add(1)
+				status := tt.statuses[min(int(n), len(tt.statuses))-1]
+				for key, values := range tt.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"message": "error"}`))
+			}))
+			defer server.Close()
+
+			ds := newTestInstance(server.Client())
+			if tt.resetIn > 0 {
+				// Window is reset after resetIn
+				windowEnd := time.Now().Truncate(15 * time.Minute).Add(15 * time.Minute)
+				start := windowEnd.Add(-tt.resetIn)
+				startedAt := time.Now()
+				ds.rateLimiter.now = func() time.Time { return start.Add(time.Since(startedAt)) }
+			}
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_, err = ds.makeHTTPRequest(ctx, req)
+
+			if requests.Load() != tt.requests {
+				t.Errorf("expected %d requests, got %d", tt.requests, requests.Load())
+			}
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			apiErr := &StravaAPIError{}
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.wantStatus {
+				t.Fatalf("expected API error with status %d, got %v", tt.wantStatus, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package datasource
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// RevalidateTimeout limits background refresh of the stale response
+const RevalidateTimeout = 60 * time.Second
+
+// StaleResponseHeader is set in the resource response if it's served from cache after its TTL
+const StaleResponseHeader = "X-Strava-Cache-Stale"
+
+// revalidate refreshes stale response in background. Only one refresh per cache key runs at a time.
+func (ds *StravaDatasourceInstance) revalidate(requestHash string, query *StravaAPIRequest, policy *CachePolicy) {
+	if _, running := ds.revalidating.LoadOrStore(requestHash, true); running {
+		return
+	}
+
+	revalidateQuery := *query
+	go func() {
+		defer ds.revalidating.Delete(requestHash)
+
+		ctx, cancel := context.WithTimeout(WithBackgroundPriority(ds.ctx), RevalidateTimeout)
+		defer cancel()
+
+		ds.logger.Debug("Refreshing stale response", "endpoint", revalidateQuery.Endpoint)
+		_, err := ds.queryShared(ctx, requestHash, &revalidateQuery, policy)
+		if err != nil {
+			ds.logger.Warn("Error refreshing stale response", "endpoint", revalidateQuery.Endpoint, "error", err)
+		}
+	}()
+}
+
+type staleTrackerKey struct{}
+
+// staleTracker records if any of the responses used to build query result was stale
+type staleTracker struct {
+	stale atomic.Bool
+}
+
+func withStaleTracker(ctx context.Context) (context.Context, *staleTracker) {
+	tracker := &staleTracker{}
+	return context.WithValue(ctx, staleTrackerKey{}, tracker), tracker
+}
+
+func markStale(ctx context.Context) {
+	if tracker, ok := ctx.Value(staleTrackerKey{}).(*staleTracker); ok {
+		tracker.stale.Store(true)
+	}
+}
+
+// setStaleNotice adds notice to the frames built from the stale responses
+func setStaleNotice(frames data.Frames) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Notices = append(frame.Meta.Notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     "Data is served from cache and may be outdated, refresh is in progress",
+		})
+	}
+}
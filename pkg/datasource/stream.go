@@ -0,0 +1,176 @@
+package datasource
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ActivitiesStreamPath is a path of the Grafana Live channel with new activities, full channel is
+// ds/<data source uid>/activities
+const ActivitiesStreamPath = "activities"
+
+// ActivityFeedBufferSize is a number of activity batches kept for the slow subscriber before new ones are dropped
+const ActivityFeedBufferSize = 16
+
+// ActivityFeedSeenSize limits number of published activity ids kept to skip duplicates
+const ActivityFeedSeenSize = 1000
+
+// ActivityFeed delivers new activities found by background sync or webhook events to the stream subscribers
+type ActivityFeed struct {
+	dsUID       string
+	refs        int
+	mu          sync.Mutex
+	subscribers map[chan []StravaActivity]struct{}
+	// seen contains ids of the recently published activities, since the same activity might be reported by
+	// both webhook and sync. seenOrder is a ring of the same ids, the oldest one is replaced when it's full.
+	seen      map[int64]struct{}
+	seenOrder []int64
+	seenNext  int
+}
+
+var (
+	activityFeedsMu sync.Mutex
+	activityFeeds   = make(map[string]*ActivityFeed)
+)
+
+// getActivityFeed returns feed of the data source. Feed is shared by the data source instances and outlives
+// them while stream has subscribers, so stream isn't interrupted when instance is re-created after settings
+// change. Instance should release feed when it's disposed.
+func getActivityFeed(dsUID string) *ActivityFeed {
+	activityFeedsMu.Lock()
+	defer activityFeedsMu.Unlock()
+	feed, ok := activityFeeds[dsUID]
+	if !ok {
+		feed = &ActivityFeed{
+			dsUID:       dsUID,
+			subscribers: make(map[chan []StravaActivity]struct{}),
+			seen:        make(map[int64]struct{}),
+			seenOrder:   make([]int64, 0, ActivityFeedSeenSize),
+		}
+		activityFeeds[dsUID] = feed
+	}
+	feed.refs++
+	return feed
+}
+
+// Release removes feed of the deleted data source when it's not used by instances and stream subscribers
+func (f *ActivityFeed) Release() {
+	activityFeedsMu.Lock()
+	defer activityFeedsMu.Unlock()
+	f.refs--
+	f.removeUnused()
+}
+
+// removeUnused removes feed from the registry, caller should hold activityFeedsMu
+func (f *ActivityFeed) removeUnused() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.refs <= 0 && len(f.subscribers) == 0 && activityFeeds[f.dsUID] == f {
+		delete(activityFeeds, f.dsUID)
+	}
+}
+
+// Subscribe returns channel receiving new activities and function removing subscription
+func (f *ActivityFeed) Subscribe() (<-chan []StravaActivity, func()) {
+	ch := make(chan []StravaActivity, ActivityFeedBufferSize)
+	f.mu.Lock()
+	f.subscribers[ch] = struct{}{}
+	f.mu.Unlock()
+
+	return ch, func() {
+		activityFeedsMu.Lock()
+		defer activityFeedsMu.Unlock()
+		f.mu.Lock()
+		delete(f.subscribers, ch)
+		f.mu.Unlock()
+		f.removeUnused()
+	}
+}
+
+// markSeen records published activity and returns false if it was published recently, caller should hold mu
+func (f *ActivityFeed) markSeen(activityId int64) bool {
+	if _, ok := f.seen[activityId]; ok {
+		return false
+	}
+	if len(f.seenOrder) < ActivityFeedSeenSize {
+		f.seenOrder = append(f.seenOrder, activityId)
+	} else {
+		delete(f.seen, f.seenOrder[f.seenNext])
+		f.seenOrder[f.seenNext] = activityId
+		f.seenNext = (f.seenNext + 1) % ActivityFeedSeenSize
+	}
+	f.seen[activityId] = struct{}{}
+	return true
+}
+
+// Publish sends activities which weren't published yet to the subscribers. It never blocks, batch is dropped
+// for the subscriber which doesn't keep up.
+func (f *ActivityFeed) Publish(activities []StravaActivity) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	newActivities := make([]StravaActivity, 0, len(activities))
+	for _, activity := range activities {
+		if f.markSeen(activity.Id) {
+			newActivities = append(newActivities, activity)
+		}
+	}
+	if len(newActivities) == 0 {
+		return
+	}
+
+	for ch := range f.subscribers {
+		select {
+		case ch <- newActivities:
+		default:
+			log.DefaultLogger.Warn("Activity stream subscriber is too slow, activities dropped", "activities", len(newActivities))
+		}
+	}
+}
+
+// SubscribeStream allows subscription to the activities channel of the data source
+func (ds *StravaDatasourcePlugin) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if req.Path != ActivitiesStreamPath {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects publishing, activities channel is fed by the backend only
+func (ds *StravaDatasourcePlugin) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream sends new activities of the data source as data frames until the last subscriber leaves the channel
+func (ds *StravaDatasourcePlugin) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	dsInstance, err := ds.getDSInstance(ctx, req.PluginContext)
+	if err != nil {
+		return err
+	}
+	mp, err := dsInstance.getMeasurementPreference(ctx, QueryModel{})
+	if err != nil {
+		ds.logger.Debug("Cannot get measurement preference, metric units are used", "error", err)
+	}
+
+	activities, unsubscribe := dsInstance.activityFeed.Subscribe()
+	defer unsubscribe()
+
+	ds.logger.Debug("Activity stream started", "data source", req.PluginContext.DataSourceInstanceSettings.UID)
+	for {
+		select {
+		case <-ctx.Done():
+			ds.logger.Debug("Activity stream stopped", "data source", req.PluginContext.DataSourceInstanceSettings.UID)
+			return nil
+		case batch := <-activities:
+			frame := transformActivitiesToTable(batch, QueryModel{}, mp)
+			frame.Name = ActivitiesStreamPath
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				return err
+			}
+		}
+	}
+}
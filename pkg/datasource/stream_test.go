@@ -0,0 +1,85 @@
+package datasource
+
+import (
+	"slices"
+	"testing"
+)
+
+func receive(ch <-chan []StravaActivity) []int64 {
+	select {
+	case batch := <-ch:
+		return activityIds(batch)
+	default:
+		return nil
+	}
+}
+
+func TestActivityFeedPublish(t *testing.T) {
+	feed := getActivityFeed("test-publish")
+	defer feed.Release()
+	ch, unsubscribe := feed.Subscribe()
+	defer unsubscribe()
+
+	feed.Publish([]StravaActivity{{Id: 1}, {Id: 2}})
+	if ids := receive(ch); !slices.Equal(ids, []int64{1, 2}) {
+		t.Errorf("expected [1 2], got %v", ids)
+	}
+
+	// Activity reported by webhook and sync is published once
+	feed.Publish([]StravaActivity{{Id: 2}, {Id: 3}})
+	if ids := receive(ch); !slices.Equal(ids, []int64{3}) {
+		t.Errorf("expected [3], got %v", ids)
+	}
+	feed.Publish([]StravaActivity{{Id: 3}})
+	if ids := receive(ch); ids != nil {
+		t.Errorf("expected no batch, got %v", ids)
+	}
+
+	// Only the oldest ids are forgotten when the limit is reached
+	batch := make([]StravaActivity, 0, ActivityFeedSeenSize)
+	for id := int64(4); id < ActivityFeedSeenSize+3; id++ {
+		batch = append(batch, StravaActivity{Id: id})
+	}
+	feed.Publish(batch)
+	if ids := receive(ch); len(ids) != ActivityFeedSeenSize-1 {
+		t.Errorf("expected %d activities, got %d", ActivityFeedSeenSize-1, len(ids))
+	}
+	feed.Publish([]StravaActivity{{Id: 3}, {Id: ActivityFeedSeenSize + 2}, {Id: 1}, {Id: 2}})
+	if ids := receive(ch); !slices.Equal(ids, []int64{1, 2}) {
+		t.Errorf("expected forgotten [1 2], got %v", ids)
+	}
+}
+
+func TestActivityFeedRelease(t *testing.T) {
+	registered := func(feed *ActivityFeed) bool {
+		activityFeedsMu.Lock()
+		defer activityFeedsMu.Unlock()
+		return activityFeeds[feed.dsUID] == feed
+	}
+
+	// Instance re-created after settings change shares the feed
+	feed := getActivityFeed("test-release")
+	if next := getActivityFeed("test-release"); next != feed {
+		t.Fatal("expected shared feed")
+	}
+	feed.Release()
+	if !registered(feed) {
+		t.Error("expected feed used by another instance")
+	}
+
+	// Stream subscriber keeps the feed until it leaves
+	_, unsubscribe := feed.Subscribe()
+	feed.Release()
+	if !registered(feed) {
+		t.Error("expected feed used by subscriber")
+	}
+	unsubscribe()
+	if registered(feed) {
+		t.Error("expected unused feed removed")
+	}
+	if next := getActivityFeed("test-release"); next == feed {
+		t.Error("expected new feed")
+	} else {
+		next.Release()
+	}
+}
@@ -0,0 +1,355 @@
+package datasource
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/grafana/strava-datasource/pkg/grafanaclient"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	TokenStoreGrafana = "grafana"
+	TokenStoreFile    = "file"
+	TokenStoreMemory  = "memory"
+)
+
+// DefaultAthleteId identifies athlete authorized with the data source "Connect with Strava" flow. Additional
+// athletes are stored by their Strava athlete id.
+const DefaultAthleteId int64 = 0
+
+const refreshTokenKey = "refreshToken"
+
+// TokenStore persists refresh tokens obtained during authorization and token refresh, keyed by athlete id
+type TokenStore interface {
+	// GetRefreshToken returns stored refresh token of the athlete or ErrRefreshTokenNotFound
+	GetRefreshToken(ctx context.Context, athleteId int64) (string, error)
+	// SaveRefreshToken stores new refresh token of the athlete
+	SaveRefreshToken(ctx context.Context, athleteId int64, token string) error
+	// Athletes returns ids of the additional athletes which have stored tokens
+	Athletes(ctx context.Context) ([]int64, error)
+}
+
+// NewTokenStore creates token store of given type. Data source API is used by default if plugin has
+// service account token, encrypted file in the data dir otherwise.
+func NewTokenStore(storeType string, dsInfo *backend.DataSourceInstanceSettings, grafanaClient grafanaclient.GrafanaHTTPClient, saToken string, dataDir string) TokenStore {
+	initialTokens := readSecureJsonTokens(dsInfo.DecryptedSecureJSONData)
+
+	if storeType == "" {
+		storeType = TokenStoreGrafana
+		if saToken == "" {
+			storeType = TokenStoreFile
+		}
+	}
+	if storeType == TokenStoreFile && dataDir == "" {
+		cacheLogger.Warn("Data dir is not configured, refresh token will be stored in memory")
+		storeType = TokenStoreMemory
+	}
+
+	switch storeType {
+	case TokenStoreFile:
+		secret := fmt.Sprintf("%s:%s", dsInfo.UID, dsInfo.DecryptedSecureJSONData["clientSecret"])
+		filename := filepath.Join(dataDir, fmt.Sprintf("%v-refresh-token", dsInfo.ID))
+		return NewFileTokenStore(filename, secret, initialTokens)
+	case TokenStoreMemory:
+		return NewMemoryTokenStore(initialTokens)
+	default:
+		return NewGrafanaTokenStore(dsInfo, grafanaClient)
+	}
+}
+
+// GrafanaTokenStore keeps refresh token in the data source secureJsonData and updates it through
+// Grafana data source API.
+type GrafanaTokenStore struct {
+	dsInfo        *backend.DataSourceInstanceSettings
+	grafanaClient grafanaclient.GrafanaHTTPClient
+	memory        *MemoryTokenStore
+}
+
+func NewGrafanaTokenStore(dsInfo *backend.DataSourceInstanceSettings, grafanaClient grafanaclient.GrafanaHTTPClient) *GrafanaTokenStore {
+	return &GrafanaTokenStore{
+		dsInfo:        dsInfo,
+		grafanaClient: grafanaClient,
+		memory:        NewMemoryTokenStore(readSecureJsonTokens(dsInfo.DecryptedSecureJSONData)),
+	}
+}
+
+func (s *GrafanaTokenStore) GetRefreshToken(ctx context.Context, athleteId int64) (string, error) {
+	return s.memory.GetRefreshToken(ctx, athleteId)
+}
+
+func (s *GrafanaTokenStore) Athletes(ctx context.Context) ([]int64, error) {
+	return s.memory.Athletes(ctx)
+}
+
+// SaveRefreshToken saves refresh token in secureJsonData by calling update data source API endpoint.
+// Grafana keeps secure fields which are not sent, so tokens of other athletes are preserved.
+func (s *GrafanaTokenStore) SaveRefreshToken(ctx context.Context, athleteId int64, token string) error {
+	_ = s.memory.SaveRefreshToken(ctx, athleteId, token)
+
+	res, err := s.grafanaClient.Get(fmt.Sprintf("/api/datasources/uid/%s", s.dsInfo.UID))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("cannot read data source settings: %s %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	jsonData := make(map[string]any)
+	err = json.Unmarshal(body, &jsonData)
+	if err != nil {
+		return err
+	}
+	secureJsonData := make(map[string]string)
+	secureJsonData[secureJsonTokenKey(athleteId)] = token
+	jsonData["secureJsonData"] = secureJsonData
+
+	updatedData, err := json.Marshal(jsonData)
+	if err != nil {
+		return err
+	}
+
+	reqData := bytes.NewReader(updatedData)
+	updateRes, err := s.grafanaClient.DoRequest("PUT", fmt.Sprintf("/api/datasources/uid/%s", s.dsInfo.UID), reqData)
+	if err != nil {
+		return err
+	}
+	defer updateRes.Body.Close()
+	if updateRes.StatusCode >= 300 {
+		// Token is kept in memory, but it's lost after restart, so error is reported to the caller
+		updateBody, _ := io.ReadAll(updateRes.Body)
+		return fmt.Errorf("cannot save refresh token in data source settings: %s %s", updateRes.Status, strings.TrimSpace(string(updateBody)))
+	}
+	cacheLogger.Debug("data source updated", "status", updateRes.Status)
+
+	return nil
+}
+
+// FileTokenStore keeps refresh tokens in the files encrypted with AES-GCM, one file per athlete. Encryption
+// key is derived from the data source secret, so tokens can't be read if client secret changed.
+type FileTokenStore struct {
+	filename      string
+	key           []byte
+	initialTokens map[int64]string
+	memory        *MemoryTokenStore
+}
+
+func NewFileTokenStore(filename string, secret string, initialTokens map[int64]string) *FileTokenStore {
+	key := sha256.Sum256([]byte(secret))
+	return &FileTokenStore{
+		filename:      filename,
+		key:           key[:],
+		initialTokens: initialTokens,
+		memory:        NewMemoryTokenStore(nil),
+	}
+}
+
+func (s *FileTokenStore) GetRefreshToken(ctx context.Context, athleteId int64) (string, error) {
+	if token, err := s.memory.GetRefreshToken(ctx, athleteId); err == nil {
+		return token, nil
+	}
+
+	filename := s.athleteFilename(athleteId)
+	encrypted, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s.getInitialToken(athleteId)
+		}
+		return "", err
+	}
+
+	token, err := s.decrypt(encrypted)
+	if err != nil {
+		cacheLogger.Warn("Cannot decrypt refresh token", "path", filename, "error", err)
+		return s.getInitialToken(athleteId)
+	}
+
+	_ = s.memory.SaveRefreshToken(ctx, athleteId, token)
+	return token, nil
+}
+
+// getInitialToken returns token from the data source settings, used until new one is saved to the file
+func (s *FileTokenStore) getInitialToken(athleteId int64) (string, error) {
+	token := s.initialTokens[athleteId]
+	if token == "" {
+		return "", ErrRefreshTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *FileTokenStore) SaveRefreshToken(ctx context.Context, athleteId int64, token string) error {
+	encrypted, err := s.encrypt(token)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(s.athleteFilename(athleteId), encrypted, 0600)
+	if err != nil {
+		return err
+	}
+	return s.memory.SaveRefreshToken(ctx, athleteId, token)
+}
+
+func (s *FileTokenStore) Athletes(ctx context.Context) ([]int64, error) {
+	athletes := make(map[int64]bool)
+	for athleteId := range s.initialTokens {
+		athletes[athleteId] = true
+	}
+
+	files, err := filepath.Glob(s.filename + "-*")
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		athleteId, err := strconv.ParseInt(strings.TrimPrefix(f, s.filename+"-"), 10, 64)
+		if err == nil {
+			athletes[athleteId] = true
+		}
+	}
+
+	return sortedAthletes(athletes), nil
+}
+
+func (s *FileTokenStore) athleteFilename(athleteId int64) string {
+	if athleteId == DefaultAthleteId {
+		return s.filename
+	}
+	return fmt.Sprintf("%s-%d", s.filename, athleteId)
+}
+
+func (s *FileTokenStore) encrypt(token string) ([]byte, error) {
+	gcm, err := s.cipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, []byte(token), nil), nil
+}
+
+func (s *FileTokenStore) decrypt(encrypted []byte) (string, error) {
+	gcm, err := s.cipher()
+	if err != nil {
+		return "", err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(encrypted) < nonceSize {
+		return "", errors.New("encrypted token is too short")
+	}
+	token, err := gcm.Open(nil, encrypted[:nonceSize], encrypted[nonceSize:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+func (s *FileTokenStore) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// MemoryTokenStore keeps refresh tokens in memory only, tokens are lost after plugin restart
+type MemoryTokenStore struct {
+	mu     sync.RWMutex
+	tokens map[int64]string
+}
+
+func NewMemoryTokenStore(initialTokens map[int64]string) *MemoryTokenStore {
+	tokens := make(map[int64]string)
+	for athleteId, token := range initialTokens {
+		tokens[athleteId] = token
+	}
+	return &MemoryTokenStore{tokens: tokens}
+}
+
+func (s *MemoryTokenStore) GetRefreshToken(ctx context.Context, athleteId int64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	token := s.tokens[athleteId]
+	if token == "" {
+		return "", ErrRefreshTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *MemoryTokenStore) SaveRefreshToken(ctx context.Context, athleteId int64, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[athleteId] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) Athletes(ctx context.Context) ([]int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	athletes := make(map[int64]bool)
+	for athleteId, token := range s.tokens {
+		if token != "" {
+			athletes[athleteId] = true
+		}
+	}
+	return sortedAthletes(athletes), nil
+}
+
+// readSecureJsonTokens reads refresh tokens from the data source secure settings. Token of the default athlete
+// is stored as refreshToken, tokens of additional athletes as refreshToken_<athleteId>.
+func readSecureJsonTokens(secureJsonData map[string]string) map[int64]string {
+	tokens := make(map[int64]string)
+	for key, token := range secureJsonData {
+		if token == "" {
+			continue
+		}
+		if key == refreshTokenKey {
+			tokens[DefaultAthleteId] = token
+		} else if strings.HasPrefix(key, refreshTokenKey+"_") {
+			athleteId, err := strconv.ParseInt(strings.TrimPrefix(key, refreshTokenKey+"_"), 10, 64)
+			if err == nil {
+				tokens[athleteId] = token
+			}
+		}
+	}
+	return tokens
+}
+
+func secureJsonTokenKey(athleteId int64) string {
+	if athleteId == DefaultAthleteId {
+		return refreshTokenKey
+	}
+	return fmt.Sprintf("%s_%d", refreshTokenKey, athleteId)
+}
+
+// sortedAthletes returns sorted ids of the additional athletes, default one is omitted
+func sortedAthletes(athletes map[int64]bool) []int64 {
+	result := make([]int64, 0)
+	for athleteId := range athletes {
+		if athleteId != DefaultAthleteId {
+			result = append(result, athleteId)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
@@ -0,0 +1,143 @@
+package datasource
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestFileTokenStore(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "1-refresh-token")
+
+	store := NewFileTokenStore(filename, "uid:secret", map[int64]string{DefaultAthleteId: "initial"})
+	token, err := store.GetRefreshToken(ctx, DefaultAthleteId)
+	if err != nil || token != "initial" {
+		t.Fatalf("expected initial token, got %q, %v", token, err)
+	}
+
+	if err := store.SaveRefreshToken(ctx, DefaultAthleteId, "default-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveRefreshToken(ctx, 42, "athlete-token"); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encrypted), "default-token") {
+		t.Fatal("token is stored unencrypted")
+	}
+
+	// New store reads tokens from the files
+	store = NewFileTokenStore(filename, "uid:secret", nil)
+	tests := map[int64]string{DefaultAthleteId: "default-token", 42: "athlete-token"}
+	for athleteId, expected := range tests {
+		token, err := store.GetRefreshToken(ctx, athleteId)
+		if err != nil || token != expected {
+			t.Errorf("athlete %d: expected %q, got %q, %v", athleteId, expected, token, err)
+		}
+	}
+
+	athletes, err := store.Athletes(ctx)
+	if err != nil || !slices.Equal(athletes, []int64{42}) {
+		t.Errorf("expected athletes [42], got %v, %v", athletes, err)
+	}
+}
+
+func TestFileTokenStoreSecretChanged(t *testing.T) {
+	ctx := context.Background()
+	filename := filepath.Join(t.TempDir(), "1-refresh-token")
+
+	store := NewFileTokenStore(filename, "uid:secret", nil)
+	if err := store.SaveRefreshToken(ctx, DefaultAthleteId, "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	store = NewFileTokenStore(filename, "uid:new-secret", map[int64]string{DefaultAthleteId: "initial"})
+	token, err := store.GetRefreshToken(ctx, DefaultAthleteId)
+	if err != nil || token != "initial" {
+		t.Fatalf("expected initial token, got %q, %v", token, err)
+	}
+
+	store = NewFileTokenStore(filename, "uid:new-secret", nil)
+	_, err = store.GetRefreshToken(ctx, DefaultAthleteId)
+	if !errors.Is(err, ErrRefreshTokenNotFound) {
+		t.Fatalf("expected ErrRefreshTokenNotFound, got %v", err)
+	}
+}
+
+type fakeGrafanaClient struct {
+	getStatus    int
+	updateStatus int
+	updated      string
+}
+
+func (c *fakeGrafanaClient) DoRequest(method string, url string, body io.Reader) (*http.Response, error) {
+	if method == http.MethodGet {
+		return fakeResponse(c.getStatus, `{"uid": "uid", "jsonData": {}}`), nil
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	c.updated = string(data)
+	return fakeResponse(c.updateStatus, `{"message": "access denied"}`), nil
+}
+
+func (c *fakeGrafanaClient) Get(url string) (*http.Response, error) {
+	return c.DoRequest(http.MethodGet, url, nil)
+}
+
+func fakeResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestGrafanaTokenStoreSaveRefreshToken(t *testing.T) {
+	tests := []struct {
+		name         string
+		getStatus    int
+		updateStatus int
+		wantErr      bool
+	}{
+		{name: "saved", getStatus: http.StatusOK, updateStatus: http.StatusOK},
+		{name: "read forbidden", getStatus: http.StatusForbidden, updateStatus: http.StatusOK, wantErr: true},
+		{name: "update forbidden", getStatus: http.StatusOK, updateStatus: http.StatusForbidden, wantErr: true},
+		{name: "update rejected", getStatus: http.StatusOK, updateStatus: http.StatusBadRequest, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeGrafanaClient{getStatus: tt.getStatus, updateStatus: tt.updateStatus}
+			store := NewGrafanaTokenStore(&backend.DataSourceInstanceSettings{UID: "uid"}, client)
+
+			err := store.SaveRefreshToken(context.Background(), 42, "token")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !strings.Contains(client.updated, `"refreshToken_42":"token"`) {
+				t.Errorf("token is not sent in secureJsonData: %s", client.updated)
+			}
+
+			// Token is available until restart even if it's not saved
+			token, err := store.GetRefreshToken(context.Background(), 42)
+			if err != nil || token != "token" {
+				t.Errorf("expected token in memory, got %q, %v", token, err)
+			}
+		})
+	}
+}
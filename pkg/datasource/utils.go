@@ -0,0 +1,189 @@
+package datasource
+
+import (
+	"slices"
+	"time"
+
+	"github.com/grafana/strava-datasource/pkg/units"
+)
+
+const (
+	Interval1h = time.Hour
+	Interval1d = 24 * time.Hour
+	Interval1w = 7 * Interval1d
+	Interval4w = 4 * Interval1w
+)
+
+// getAggregationInterval returns aggregation interval for the given time range
+func getAggregationInterval(timeRange time.Duration) time.Duration {
+	switch {
+	case timeRange <= 4*Interval1d:
+		return Interval1h
+	case timeRange <= 90*Interval1d:
+		return Interval1d
+	case timeRange <= 365*Interval1d:
+		return Interval1w
+	default:
+		return Interval4w
+	}
+}
+
+// getAggregationIntervalFromQuery returns aggregation interval set in the query
+func getAggregationIntervalFromQuery(interval string) time.Duration {
+	switch interval {
+	case IntervalHour:
+		return Interval1h
+	case IntervalDay:
+		return Interval1d
+	case IntervalWeek:
+		return Interval1w
+	default:
+		return Interval4w
+	}
+}
+
+func getRunTypes() []string {
+	return []string{"Run", "TrailRun", "VirtualRun"}
+}
+
+func getRideTypes() []string {
+	return []string{
+		"EBikeRide",
+		"EMountainBikeRide",
+		"GravelRide",
+		"Handcycle",
+		"MountainBikeRide",
+		"Ride",
+		"Velomobile",
+		"VirtualRide",
+		"Wheelchair",
+	}
+}
+
+func getWalkTypes() []string {
+	return []string{"Hike", "Walk"}
+}
+
+// filterActivities returns activities matching given activity type. Ride, Run, Walk and Other
+// are treated as groups of sport types.
+func filterActivities(activities []StravaActivity, activityType string) []StravaActivity {
+	if activityType == "" {
+		return activities
+	}
+
+	filtered := make([]StravaActivity, 0)
+	for _, activity := range activities {
+		sportType := activity.SportType
+		match := false
+		switch activityType {
+		case "Ride":
+			match = slices.Contains(getRideTypes(), sportType)
+		case "Run":
+			match = slices.Contains(getRunTypes(), sportType)
+		case "Walk":
+			match = slices.Contains(getWalkTypes(), sportType)
+		case "Other":
+			match = !slices.Contains(getRideTypes(), sportType) &&
+				!slices.Contains(getRunTypes(), sportType) &&
+				!slices.Contains(getWalkTypes(), sportType)
+		default:
+			match = sportType == activityType
+		}
+		if match {
+			filtered = append(filtered, activity)
+		}
+	}
+	return filtered
+}
+
+// GraphSmoothWindow is a moving average window used to smooth activity streams
+const GraphSmoothWindow = 20
+
+// getPaceOrSpeed returns pace for runs and speed for other activities along with the field unit
+func getPaceOrSpeed(mps float64, isRun bool, mp units.MeasurementPreference) (float64, string) {
+	if isRun {
+		return mp.Pace(mps), units.UnitDuration
+	}
+	return mp.Speed(mps), mp.SpeedUnit()
+}
+
+func isRun(sportType string) bool {
+	return sportType == "Run"
+}
+
+// convertData applies conversion function to the each non-null point of the stream
+func convertData(values []*float64, convert func(float64) float64) []*float64 {
+	for i, point := range values {
+		if point != nil {
+			converted := convert(*point)
+			values[i] = &converted
+		}
+	}
+	return values
+}
+
+// smoothData calculates simple moving average with GraphSmoothWindow size
+func smoothData(values []*float64) []*float64 {
+	// It's not possible to calculate MA if n greater than number of points
+	n := min(GraphSmoothWindow, len(values))
+	sma := make([]*float64, 0, len(values))
+
+	average := func(window []*float64) *float64 {
+		sum := 0.0
+		count := 0
+		for _, point := range window {
+			if point != nil {
+				sum += *point
+				count++
+			}
+		}
+		if count == 0 {
+			return nil
+		}
+		avg := sum / float64(count)
+		return &avg
+	}
+
+	// Initial window
+	initial := average(values[:n])
+	for i := 0; i < n; i++ {
+		sma = append(sma, initial)
+	}
+
+	for i := n; i < len(values); i++ {
+		sma = append(sma, average(values[i-n+1:i+1]))
+	}
+	return sma
+}
+
+// expandDataStream expands stream data to normal array with nulls for non-existing points.
+// Data comes as a kind of sparse array. Time stream contains offset of data
+// points, for example:
+// heartrate: [70,81,82,81,99,96,97,98,99]
+// time:      [0, 4, 5, 6, 20,21,22,23,24]
+// So last value of the time stream is a highest index in data array
+func expandDataStream(values []*float64, timeTicks []int64, startTS int64, startIndex int, endIndex int) ([]*float64, []time.Time) {
+	endIndex = min(endIndex, len(timeTicks)-1)
+	if startIndex < 0 || startIndex > endIndex {
+		return []*float64{}, []time.Time{}
+	}
+
+	firstTsIndex := timeTicks[startIndex]
+	streamLength := int(timeTicks[endIndex] - firstTsIndex)
+	streamValues := make([]*float64, streamLength)
+	segmentTicks := make([]time.Time, 0, streamLength)
+
+	ts := startTS + firstTsIndex
+	for i := 0; i < streamLength; i++ {
+		segmentTicks = append(segmentTicks, time.Unix(ts, 0))
+		ts++
+	}
+	for i := startIndex; i < endIndex && i < len(values); i++ {
+		idx := int(timeTicks[i] - firstTsIndex)
+		if idx >= 0 && idx < streamLength {
+			streamValues[idx] = values[i]
+		}
+	}
+
+	return streamValues, segmentTicks
+}
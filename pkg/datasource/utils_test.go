@@ -0,0 +1,148 @@
+package datasource
+
+import (
+	"testing"
+	"time"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func floats(values ...interface{}) []*float64 {
+	result := make([]*float64, len(values))
+	for i, v := range values {
+		if v != nil {
+			result[i] = float(float64(v.(int)))
+		}
+	}
+	return result
+}
+
+func equalValues(a []*float64, b []*float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if (a[i] == nil) != (b[i] == nil) || (a[i] != nil && *a[i] != *b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatValues(values []*float64) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		if v != nil {
+			result[i] = *v
+		}
+	}
+	return result
+}
+
+func TestExpandDataStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     []*float64
+		ticks      []int64
+		startIndex int
+		endIndex   int
+		expected   []*float64
+		firstTick  int64
+	}{
+		{
+			name:       "sparse stream",
+			values:     floats(70, 81, 82, 99, 96),
+			ticks:      []int64{0, 2, 3, 6, 7},
+			startIndex: 0,
+			endIndex:   4,
+			expected:   floats(70, nil, 81, 82, nil, nil, 99),
+		},
+		{
+			name:       "segment slice",
+			values:     floats(70, 81, 82, 99, 96),
+			ticks:      []int64{0, 2, 3, 6, 7},
+			startIndex: 1,
+			endIndex:   3,
+			expected:   floats(81, 82, nil, nil),
+			firstTick:  2,
+		},
+		{
+			name:       "end index out of range",
+			values:     floats(1, 2, 3),
+			ticks:      []int64{0, 1, 2},
+			startIndex: 0,
+			endIndex:   10,
+			expected:   floats(1, 2),
+		},
+		{
+			name:       "invalid range",
+			values:     floats(1, 2, 3),
+			ticks:      []int64{0, 1, 2},
+			startIndex: 2,
+			endIndex:   1,
+			expected:   floats(),
+		},
+	}
+
+	startTS := int64(1700000000)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, ticks := expandDataStream(tt.values, tt.ticks, startTS, tt.startIndex, tt.endIndex)
+			if !equalValues(values, tt.expected) {
+				t.Errorf("expected %v, got %v", formatValues(tt.expected), formatValues(values))
+			}
+			if len(ticks) != len(values) {
+				t.Fatalf("expected %d ticks, got %d", len(values), len(ticks))
+			}
+			for i, tick := range ticks {
+				if expected := time.Unix(startTS+tt.firstTick+int64(i), 0); !tick.Equal(expected) {
+					t.Errorf("tick %d: expected %v, got %v", i, expected, tick)
+				}
+			}
+		})
+	}
+}
+
+func TestSmoothData(t *testing.T) {
+	constant := make([]interface{}, 30)
+	for i := range constant {
+		constant[i] = 5
+	}
+	ramp := make([]interface{}, GraphSmoothWindow+1)
+	for i := range ramp {
+		ramp[i] = i
+	}
+	nullWindow := make([]interface{}, GraphSmoothWindow)
+
+	tests := []struct {
+		name     string
+		values   []*float64
+		expected []*float64
+	}{
+		{name: "empty", values: floats(), expected: floats()},
+		{name: "shorter than window", values: floats(1, 2, 3), expected: floats(2, 2, 2)},
+		{name: "constant", values: floats(constant...), expected: floats(constant...)},
+		{name: "nulls skipped", values: floats(2, nil, 4), expected: floats(3, 3, 3)},
+		{name: "all nulls", values: floats(nil, nil), expected: floats(nil, nil)},
+		{name: "null window", values: floats(append(nullWindow, 4)...), expected: floats(append(nullWindow, 4)...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			smoothed := smoothData(tt.values)
+			if !equalValues(smoothed, tt.expected) {
+				t.Errorf("expected %v, got %v", formatValues(tt.expected), formatValues(smoothed))
+			}
+		})
+	}
+
+	t.Run("moving window", func(t *testing.T) {
+		smoothed := smoothData(floats(ramp...))
+		// Initial window average is (0+...+19)/20, next one is (1+...+20)/20
+		if *smoothed[0] != 9.5 || *smoothed[GraphSmoothWindow-1] != 9.5 || *smoothed[GraphSmoothWindow] != 10.5 {
+			t.Errorf("unexpected moving average %v", formatValues(smoothed))
+		}
+	})
+}
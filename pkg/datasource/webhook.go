@@ -0,0 +1,209 @@
+package datasource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// WebhookVerifyTokenKey is a key of the secure data source setting containing token used to create Strava
+// push subscription
+const WebhookVerifyTokenKey = "webhookVerifyToken"
+
+// WebhookEventTimeout limits processing of the single webhook event
+const WebhookEventTimeout = 2 * time.Minute
+
+// StravaAPISubscriptionsUrl lists push subscriptions of the application
+const StravaAPISubscriptionsUrl = "https://www.strava.com/api/v3/push_subscriptions"
+
+// WebhookSubscriptionCheckInterval limits lookups of the push subscription while it's unknown, so events with
+// random subscription ids don't cause API requests
+const WebhookSubscriptionCheckInterval = time.Minute
+
+// Webhook event object and aspect types
+const (
+	WebhookObjectActivity = "activity"
+	WebhookObjectAthlete  = "athlete"
+	WebhookAspectCreate   = "create"
+	WebhookAspectUpdate   = "update"
+	WebhookAspectDelete   = "delete"
+)
+
+// WebhookEvent is an event sent by Strava push subscription
+// https://developers.strava.com/docs/webhooks/
+type WebhookEvent struct {
+	ObjectType     string                 `json:"object_type"`
+	ObjectId       int64                  `json:"object_id"`
+	AspectType     string                 `json:"aspect_type"`
+	OwnerId        int64                  `json:"owner_id"`
+	SubscriptionId int64                  `json:"subscription_id"`
+	EventTime      int64                  `json:"event_time"`
+	Updates        map[string]interface{} `json:"updates"`
+}
+
+// webhookSubscription keeps id of the application push subscription. Strava allows single subscription per
+// application, so events with another subscription id are forged and rejected.
+type webhookSubscription struct {
+	mu        sync.Mutex
+	id        int64
+	checkedAt time.Time
+}
+
+// deauthorized returns true if athlete revoked access of the application
+func (e *WebhookEvent) deauthorized() bool {
+	return e.ObjectType == WebhookObjectAthlete && fmt.Sprint(e.Updates["authorized"]) == "false"
+}
+
+// VerifyWebhookToken checks token sent by Strava when push subscription is created. Subscription is created
+// after validation, so its id is looked up again when the first event is received.
+func (ds *StravaDatasourceInstance) VerifyWebhookToken(token string) bool {
+	verifyToken := ds.dsInfo.DecryptedSecureJSONData[WebhookVerifyTokenKey]
+	if verifyToken == "" || token != verifyToken {
+		return false
+	}
+
+	ds.webhookSubscription.mu.Lock()
+	defer ds.webhookSubscription.mu.Unlock()
+	ds.webhookSubscription.id = 0
+	ds.webhookSubscription.checkedAt = time.Time{}
+	return true
+}
+
+// VerifyWebhookSubscription checks that event is sent by the push subscription of the application
+func (ds *StravaDatasourceInstance) VerifyWebhookSubscription(ctx context.Context, subscriptionId int64) bool {
+	if ds.dsInfo.DecryptedSecureJSONData[WebhookVerifyTokenKey] == "" || subscriptionId == 0 {
+		return false
+	}
+
+	s := &ds.webhookSubscription
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id == 0 && time.Since(s.checkedAt) >= WebhookSubscriptionCheckInterval {
+		s.checkedAt = time.Now()
+		id, err := ds.getWebhookSubscriptionId(ctx)
+		if err != nil {
+			ds.logger.Warn("Cannot get webhook subscription", "error", err)
+		}
+		s.id = id
+	}
+	return s.id != 0 && s.id == subscriptionId
+}
+
+// getWebhookSubscriptionId returns id of the push subscription of the application or 0 if it doesn't exist
+// https://developers.strava.com/docs/webhooks/#view-a-subscription
+func (ds *StravaDatasourceInstance) getWebhookSubscriptionId(ctx context.Context) (int64, error) {
+	params := url.Values{
+		"client_id":     {ds.settings.ClientID},
+		"client_secret": {ds.dsInfo.DecryptedSecureJSONData["clientSecret"]},
+	}
+	req, err := http.NewRequest(http.MethodGet, StravaAPISubscriptionsUrl+"?"+params.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	body, err := ds.makeHTTPRequest(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	subscriptions := make([]struct {
+		Id int64 `json:"id"`
+	}, 0)
+	if err := json.Unmarshal(body, &subscriptions); err != nil {
+		return 0, fmt.Errorf("error parsing subscriptions: %w", err)
+	}
+	if len(subscriptions) == 0 {
+		return 0, nil
+	}
+	return subscriptions[0].Id, nil
+}
+
+// HandleWebhookEvent updates cache and activity store according to the event. Strava expects response within
+// 2 seconds, so changed activity is loaded in background.
+func (ds *StravaDatasourceInstance) HandleWebhookEvent(event *WebhookEvent) {
+	go func() {
+		ctx, cancel := context.WithTimeout(WithBackgroundPriority(ds.ctx), WebhookEventTimeout)
+		defer cancel()
+
+		err := ds.processWebhookEvent(ctx, event)
+		if err != nil {
+			ds.logger.Warn("Error processing webhook event", "object", event.ObjectType, "id", event.ObjectId, "aspect", event.AspectType, "error", err)
+		}
+	}()
+}
+
+func (ds *StravaDatasourceInstance) processWebhookEvent(ctx context.Context, event *WebhookEvent) error {
+	athleteId, ok, err := ds.athleteForOwner(ctx, event.OwnerId)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		ds.logger.Debug("Webhook event of unknown athlete ignored", "owner", event.OwnerId)
+		return nil
+	}
+	useStore := ds.useActivityStore(athleteId, "")
+
+	if event.deauthorized() {
+		ds.logger.Info("Athlete deauthorized the application, cached data removed", "athlete", event.OwnerId)
+		ds.cache.Delete(accessTokenCacheKey(athleteId))
+		if athleteId == DefaultAthleteId {
+			// Synced activities belong to the default athlete
+			ds.ResetCache()
+		} else {
+			ds.cache.Flush()
+		}
+		return nil
+	}
+	if event.ObjectType != WebhookObjectActivity {
+		return nil
+	}
+
+	ds.InvalidateActivityCache(event.ObjectId)
+	switch event.AspectType {
+	case WebhookAspectDelete:
+		if useStore {
+			return ds.activityStore.DeleteActivity(event.ObjectId)
+		}
+	case WebhookAspectCreate, WebhookAspectUpdate:
+		activityId := strconv.FormatInt(event.ObjectId, 10)
+		if useStore {
+			// Stored details are outdated, activity is loaded from API and saved again
+			if err := ds.activityStore.DeleteDetails(event.ObjectId); err != nil {
+				return err
+			}
+		}
+		activity, err := ds.GetActivity(ctx, activityId, athleteId, "")
+		if err != nil {
+			return err
+		}
+		if event.AspectType == WebhookAspectCreate && athleteId == DefaultAthleteId {
+			ds.activityFeed.Publish([]StravaActivity{*activity})
+		}
+		if ds.prefetcher != nil && athleteId == DefaultAthleteId {
+			return ds.prefetcher.PrefetchActivityStreams(activityId)
+		}
+	}
+	return nil
+}
+
+// athleteForOwner returns id used to query data of the athlete who owns the event object
+func (ds *StravaDatasourceInstance) athleteForOwner(ctx context.Context, ownerId int64) (int64, bool, error) {
+	athletes, err := ds.GetAthletes(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	if slices.Contains(athletes, ownerId) {
+		return ownerId, true, nil
+	}
+
+	athlete, err := ds.GetAthlete(ctx, DefaultAthleteId, "")
+	if err != nil {
+		return 0, false, err
+	}
+	return DefaultAthleteId, athlete.Id == ownerId, nil
+}
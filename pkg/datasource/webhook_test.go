@@ -0,0 +1,89 @@
+package datasource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestVerifyWebhookSubscription(t *testing.T) {
+	tests := []struct {
+		name           string
+		verifyToken    string
+		subscriptions  string
+		subscriptionId int64
+		valid          bool
+	}{
+		{name: "subscription of the application", verifyToken: "token", subscriptions: `[{"id": 42}]`, subscriptionId: 42, valid: true},
+		{name: "another subscription", verifyToken: "token", subscriptions: `[{"id": 42}]`, subscriptionId: 43},
+		{name: "missing subscription id", verifyToken: "token", subscriptions: `[{"id": 42}]`, subscriptionId: 0},
+		{name: "no subscriptions", verifyToken: "token", subscriptions: `[]`, subscriptionId: 42},
+		{name: "webhook not configured", verifyToken: "", subscriptions: `[{"id": 42}]`, subscriptionId: 42},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				requests.Add(1)
+				rec := httptest.NewRecorder()
+				if req.URL.Path != "/api/v3/push_subscriptions" || req.URL.Query().Get("client_secret") != "secret" {
+					rec.WriteHeader(http.StatusNotFound)
+				} else {
+					_, _ = rec.Write([]byte(tt.subscriptions))
+				}
+				return rec.Result(), nil
+			})}
+			ds := newTestInstance(client)
+			ds.settings = &StravaDatasourceSettingsDTO{ClientID: "1"}
+			ds.dsInfo = &backend.DataSourceInstanceSettings{DecryptedSecureJSONData: map[string]string{
+				"clientSecret":        "secret",
+				WebhookVerifyTokenKey: tt.verifyToken,
+			}}
+
+			for i := 0; i < 2; i++ {
+				if valid := ds.VerifyWebhookSubscription(context.Background(), tt.subscriptionId); valid != tt.valid {
+					t.Errorf("expected valid %v, got %v", tt.valid, valid)
+				}
+			}
+			// Subscription is looked up once
+			if tt.verifyToken != "" && tt.subscriptionId != 0 && requests.Load() != 1 {
+				t.Errorf("expected single subscription request, got %d", requests.Load())
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookTokenResetsSubscription(t *testing.T) {
+	subscriptionId := atomic.Int64{}
+	subscriptionId.Store(42)
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		_, _ = fmt.Fprintf(rec, `[{"id": %d}]`, subscriptionId.Load())
+		return rec.Result(), nil
+	})}
+	ds := newTestInstance(client)
+	ds.settings = &StravaDatasourceSettingsDTO{ClientID: "1"}
+	ds.dsInfo = &backend.DataSourceInstanceSettings{DecryptedSecureJSONData: map[string]string{WebhookVerifyTokenKey: "token"}}
+
+	if !ds.VerifyWebhookSubscription(context.Background(), 42) {
+		t.Fatal("expected valid subscription")
+	}
+	if ds.VerifyWebhookToken("invalid") {
+		t.Fatal("expected invalid verify token")
+	}
+
+	// Subscription is re-created
+	subscriptionId.Store(43)
+	if !ds.VerifyWebhookToken("token") {
+		t.Fatal("expected valid verify token")
+	}
+	if ds.VerifyWebhookSubscription(context.Background(), 42) || !ds.VerifyWebhookSubscription(context.Background(), 43) {
+		t.Error("expected events of the new subscription only")
+	}
+}
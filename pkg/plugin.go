@@ -31,6 +31,7 @@ func main() {
 		CallResourceHandler: httpResourceHandler,
 		QueryDataHandler:    ds,
 		CheckHealthHandler:  ds,
+		StreamHandler:       ds,
 	})
 	if err != nil {
 		log.DefaultLogger.Error("Error starting Strava datasource", "error", err.Error())
@@ -57,6 +58,12 @@ func Init(mux *http.ServeMux) *datasource.StravaDatasourcePlugin {
 	mux.HandleFunc("/strava-api", ds.StravaAPIHandler)
 	mux.HandleFunc("/reset-access-token", ds.ResetAccessTokenHandler)
 	mux.HandleFunc("/reset-cache", ds.ResetCacheHandler)
+	mux.HandleFunc("/cache/entries", ds.CacheEntriesHandler)
+	mux.HandleFunc("/cache/stats", ds.CacheStatsHandler)
+	mux.HandleFunc("/cache/invalidate", ds.CacheInvalidateHandler)
+	mux.HandleFunc("/prefetch/status", ds.PrefetchStatusHandler)
+	mux.HandleFunc("/webhook", ds.WebhookHandler)
+	mux.HandleFunc("/athletes", ds.AthletesHandler)
 
 	return ds
 }
@@ -0,0 +1,128 @@
+package units
+
+import "math"
+
+// MeasurementPreference is an athlete's preferred measurement system, as returned by Strava API
+type MeasurementPreference string
+
+const (
+	Meters MeasurementPreference = "meters"
+	Feet   MeasurementPreference = "feet"
+)
+
+// Grafana field units
+const (
+	UnitMeters   = "lengthm"
+	UnitFeet     = "lengthft"
+	UnitMiles    = "lengthmi"
+	UnitKmh      = "velocitykmh"
+	UnitMph      = "velocitymph"
+	UnitDuration = "dthms"
+	UnitWatt     = "watt"
+	UnitJoule    = "joule"
+	UnitBPM      = "bpm"
+	UnitPercent  = "percent"
+	UnitNone     = "none"
+)
+
+// MaxPace limits pace to 10 min/km to avoid spikes on graph (pace is a reversed speed)
+const MaxPace = 10 * 60
+
+// Parse returns measurement preference for the given value and false if value is unknown
+func Parse(value string) (MeasurementPreference, bool) {
+	switch MeasurementPreference(value) {
+	case Meters:
+		return Meters, true
+	case Feet:
+		return Feet, true
+	default:
+		return Meters, false
+	}
+}
+
+// Distance converts meters to the preferred distance units (meters or miles)
+func (mp MeasurementPreference) Distance(meters float64) float64 {
+	if mp == Feet {
+		return MetersToMiles(meters)
+	}
+	return meters
+}
+
+// Length converts meters to the preferred length units (meters or feet)
+func (mp MeasurementPreference) Length(meters float64) float64 {
+	if mp == Feet {
+		return MetersToFeet(meters)
+	}
+	return meters
+}
+
+// Speed converts m/s to the preferred speed units (km/h or mph)
+func (mp MeasurementPreference) Speed(mps float64) float64 {
+	speedKmph := VelocityToSpeed(mps)
+	if mp == Feet {
+		return MetersToMiles(speedKmph * 1000)
+	}
+	return speedKmph
+}
+
+// Pace converts m/s to the preferred pace in seconds (per km or per mile)
+func (mp MeasurementPreference) Pace(mps float64) float64 {
+	paceMinkm := VelocityToPace(mps)
+	if mp == Feet {
+		return PaceToMiles(paceMinkm)
+	}
+	return paceMinkm
+}
+
+// DistanceUnit returns Grafana unit for the preferred distance
+func (mp MeasurementPreference) DistanceUnit() string {
+	if mp == Feet {
+		return UnitMiles
+	}
+	return UnitMeters
+}
+
+// LengthUnit returns Grafana unit for the preferred length
+func (mp MeasurementPreference) LengthUnit() string {
+	if mp == Feet {
+		return UnitFeet
+	}
+	return UnitMeters
+}
+
+// SpeedUnit returns Grafana unit for the preferred speed
+func (mp MeasurementPreference) SpeedUnit() string {
+	if mp == Feet {
+		return UnitMph
+	}
+	return UnitKmh
+}
+
+// MetersToFeet converts meters to feet
+func MetersToFeet(value float64) float64 {
+	return value / 0.3048
+}
+
+// MetersToMiles converts meters to miles
+func MetersToMiles(value float64) float64 {
+	return value / 1609.344
+}
+
+// PaceToMiles converts pace in seconds per km to seconds per mile
+func PaceToMiles(value float64) float64 {
+	return value * 1609.344 / 1000
+}
+
+// VelocityToPace converts m/s to seconds per km
+func VelocityToPace(mps float64) float64 {
+	if mps == 0 {
+		return 0
+	}
+	pace := float64(float32(1000 / mps))
+	return math.Min(MaxPace, pace)
+}
+
+// VelocityToSpeed converts m/s to km/h
+func VelocityToSpeed(mps float64) float64 {
+	return mps * 3.6
+}
@@ -0,0 +1,117 @@
+package units
+
+import (
+	"math"
+	"testing"
+)
+
+func almostEqual(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected MeasurementPreference
+		ok       bool
+	}{
+		{value: "meters", expected: Meters, ok: true},
+		{value: "feet", expected: Feet, ok: true},
+		{value: "", expected: Meters, ok: false},
+		{value: "yards", expected: Meters, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mp, ok := Parse(tt.value)
+			if mp != tt.expected || ok != tt.ok {
+				t.Errorf("expected %s, %v, got %s, %v", tt.expected, tt.ok, mp, ok)
+			}
+		})
+	}
+}
+
+func TestVelocityToPace(t *testing.T) {
+	tests := []struct {
+		name     string
+		mps      float64
+		expected float64
+	}{
+		{name: "zero velocity", mps: 0, expected: 0},
+		{name: "5 min/km", mps: 1000.0 / 300, expected: 300},
+		{name: "rounded to float32", mps: 3, expected: float64(float32(1000.0 / 3))},
+		{name: "max pace", mps: 1000.0 / MaxPace, expected: MaxPace},
+		{name: "capped by max pace", mps: 0.5, expected: MaxPace},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if pace := VelocityToPace(tt.mps); pace != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, pace)
+			}
+		})
+	}
+}
+
+func TestPaceToMiles(t *testing.T) {
+	if pace := PaceToMiles(300); !almostEqual(pace, 482.8032) {
+		t.Errorf("expected 482.8032, got %v", pace)
+	}
+	if pace := PaceToMiles(0); pace != 0 {
+		t.Errorf("expected 0, got %v", pace)
+	}
+}
+
+func TestConversions(t *testing.T) {
+	tests := []struct {
+		name     string
+		mp       MeasurementPreference
+		convert  func(MeasurementPreference, float64) float64
+		value    float64
+		expected float64
+	}{
+		{name: "distance meters", mp: Meters, convert: MeasurementPreference.Distance, value: 1609.344, expected: 1609.344},
+		{name: "distance feet", mp: Feet, convert: MeasurementPreference.Distance, value: 1609.344, expected: 1},
+		{name: "length meters", mp: Meters, convert: MeasurementPreference.Length, value: 3.048, expected: 3.048},
+		{name: "length feet", mp: Feet, convert: MeasurementPreference.Length, value: 3.048, expected: 10},
+		{name: "speed meters", mp: Meters, convert: MeasurementPreference.Speed, value: 10, expected: 36},
+		{name: "speed feet", mp: Feet, convert: MeasurementPreference.Speed, value: 10, expected: 22.369363},
+		{name: "pace meters", mp: Meters, convert: MeasurementPreference.Pace, value: 1000.0 / 300, expected: 300},
+		{name: "pace feet", mp: Feet, convert: MeasurementPreference.Pace, value: 1000.0 / 300, expected: 482.8032},
+		{name: "pace feet capped", mp: Feet, convert: MeasurementPreference.Pace, value: 0.5, expected: MaxPace * 1.609344},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if value := tt.convert(tt.mp, tt.value); !almostEqual(value, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		mp       MeasurementPreference
+		distance string
+		length   string
+		speed    string
+	}{
+		{mp: Meters, distance: UnitMeters, length: UnitMeters, speed: UnitKmh},
+		{mp: Feet, distance: UnitMiles, length: UnitFeet, speed: UnitMph},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mp), func(t *testing.T) {
+			if unit := tt.mp.DistanceUnit(); unit != tt.distance {
+				t.Errorf("expected distance unit %s, got %s", tt.distance, unit)
+			}
+			if unit := tt.mp.LengthUnit(); unit != tt.length {
+				t.Errorf("expected length unit %s, got %s", tt.length, unit)
+			}
+			if unit := tt.mp.SpeedUnit(); unit != tt.speed {
+				t.Errorf("expected speed unit %s, got %s", tt.speed, unit)
+			}
+		})
+	}
+}
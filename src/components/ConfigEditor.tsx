@@ -1,10 +1,39 @@
-import React, { ChangeEvent, useEffect, useCallback } from 'react';
-import { Button, InlineField, Input, InlineFieldRow, InlineSwitch, Alert, VerticalGroup } from '@grafana/ui';
-import { DataSourcePluginOptionsEditorProps, DataSourceSettings } from '@grafana/data';
-import { StravaJsonData, StravaSecureJsonData } from '../types';
+import React, { ChangeEvent, useEffect, useCallback, useState } from 'react';
+import {
+  Button,
+  InlineField,
+  Input,
+  InlineFieldRow,
+  InlineSwitch,
+  Alert,
+  VerticalGroup,
+  Select,
+  TextArea,
+} from '@grafana/ui';
+import { DataSourcePluginOptionsEditorProps, DataSourceSettings, SelectableValue } from '@grafana/data';
+import {
+  StravaCachePolicy,
+  StravaJsonData,
+  StravaMeasurementPreference,
+  StravaSecureJsonData,
+  StravaTokenStore,
+} from '../types';
 
 const AuthCodePattern = /code=([\w]+)/;
 
+const measurementPreferenceOptions: Array<SelectableValue<StravaMeasurementPreference | ''>> = [
+  { value: '', label: 'Athlete preference' },
+  { value: StravaMeasurementPreference.Meters, label: 'Metric' },
+  { value: StravaMeasurementPreference.Feet, label: 'Imperial' },
+];
+
+const tokenStoreOptions: Array<SelectableValue<StravaTokenStore | ''>> = [
+  { value: '', label: 'Default' },
+  { value: StravaTokenStore.Grafana, label: 'Data source' },
+  { value: StravaTokenStore.File, label: 'Encrypted file' },
+  { value: StravaTokenStore.Memory, label: 'Memory' },
+];
+
 export type Props = DataSourcePluginOptionsEditorProps<StravaJsonData, StravaSecureJsonData>;
 
 type StravaSettings = DataSourceSettings<StravaJsonData, StravaSecureJsonData>;
@@ -39,6 +68,11 @@ export const ConfigEditor = (props: Props) => {
     [onOptionsChange]
   );
 
+  const [cachePoliciesText, setCachePoliciesText] = useState(
+    optionsWithDefaults.jsonData.cachePolicies ? JSON.stringify(optionsWithDefaults.jsonData.cachePolicies, null, 2) : ''
+  );
+  const [cachePoliciesError, setCachePoliciesError] = useState('');
+
   useEffect(() => {
     updateDatasource(optionsWithDefaults);
     // eslint-disable-next-line react-hooks/exhaustive-deps
@@ -54,6 +88,95 @@ export const ConfigEditor = (props: Props) => {
     });
   };
 
+  const onDiskCacheSizeChange = (value: string) => {
+    const diskCacheSizeMB = value === '' ? undefined : Number(value);
+    onOptionsChange({
+      ...optionsWithDefaults,
+      jsonData: {
+        ...optionsWithDefaults.jsonData,
+        diskCacheSizeMB,
+      },
+    });
+  };
+
+  const onSyncDepthChange = (value: string) => {
+    const syncDepth = value === '' ? undefined : Number(value);
+    onOptionsChange({
+      ...optionsWithDefaults,
+      jsonData: {
+        ...optionsWithDefaults.jsonData,
+        syncDepth,
+      },
+    });
+  };
+
+  const onSyncIntervalChange = (syncInterval: string) => {
+    onOptionsChange({
+      ...optionsWithDefaults,
+      jsonData: {
+        ...optionsWithDefaults.jsonData,
+        syncInterval: syncInterval || undefined,
+      },
+    });
+  };
+
+  const onSyncStreamsChange = (value: string) => {
+    const syncStreams = value
+      .split(',')
+      .map((s) => s.trim())
+      .filter((s) => s !== '');
+    onOptionsChange({
+      ...optionsWithDefaults,
+      jsonData: {
+        ...optionsWithDefaults.jsonData,
+        syncStreams: syncStreams.length ? syncStreams : undefined,
+      },
+    });
+  };
+
+  const onCachePoliciesBlur = () => {
+    let cachePolicies: StravaCachePolicy[] | undefined;
+    try {
+      cachePolicies = cachePoliciesText.trim() ? JSON.parse(cachePoliciesText) : undefined;
+    } catch (err: any) {
+      setCachePoliciesError(err?.message || 'Invalid JSON');
+      return;
+    }
+    if (cachePolicies && !Array.isArray(cachePolicies)) {
+      setCachePoliciesError('Cache policies should be a list');
+      return;
+    }
+
+    setCachePoliciesError('');
+    onOptionsChange({
+      ...optionsWithDefaults,
+      jsonData: {
+        ...optionsWithDefaults.jsonData,
+        cachePolicies,
+      },
+    });
+  };
+
+  const onMeasurementPreferenceChange = (measurementPreference?: StravaMeasurementPreference) => {
+    onOptionsChange({
+      ...optionsWithDefaults,
+      jsonData: {
+        ...optionsWithDefaults.jsonData,
+        measurementPreference,
+      },
+    });
+  };
+
+  const onTokenStoreChange = (tokenStore?: StravaTokenStore) => {
+    onOptionsChange({
+      ...optionsWithDefaults,
+      jsonData: {
+        ...optionsWithDefaults.jsonData,
+        tokenStore,
+      },
+    });
+  };
+
   const onResetClientSecret = () => {
     onOptionsChange({
       ...optionsWithDefaults,
@@ -84,10 +207,34 @@ export const ConfigEditor = (props: Props) => {
     });
   };
 
+  const onResetWebhookVerifyToken = () => {
+    onOptionsChange({
+      ...optionsWithDefaults,
+      secureJsonFields: {
+        ...optionsWithDefaults.secureJsonFields,
+        webhookVerifyToken: false,
+      },
+    });
+  };
+
+  const onWebhookVerifyTokenChange = (webhookVerifyToken: string) => {
+    onOptionsChange({
+      ...optionsWithDefaults,
+      secureJsonData: {
+        ...optionsWithDefaults.secureJsonData,
+        webhookVerifyToken,
+      },
+    });
+  };
+
   const isLocationContainsCode = () => {
     return AuthCodePattern.test(window.location.search);
   };
 
+  const isLocationContainsAddAthlete = () => {
+    return /state=add_athlete/.test(window.location.search);
+  };
+
   const isLocationContainsError = () => {
     return /error=/.test(window.location.search);
   };
@@ -101,6 +248,7 @@ export const ConfigEditor = (props: Props) => {
   };
 
   const connectWithStravaHref = getConnectWithStravaHref();
+  const addAthleteHref = `${connectWithStravaHref}&state=add_athlete`;
   const showConnectWithStravaButton =
     optionsWithDefaults.jsonData.clientID && optionsWithDefaults.secureJsonFields.clientSecret;
 
@@ -162,6 +310,32 @@ export const ConfigEditor = (props: Props) => {
             </InlineField>
           )}
         </InlineFieldRow>
+        <InlineFieldRow>
+          {optionsWithDefaults.secureJsonFields && optionsWithDefaults.secureJsonFields.webhookVerifyToken ? (
+            <>
+              <InlineField label="Webhook token" labelWidth={16}>
+                <Input placeholder="Configured" width={50} disabled />
+              </InlineField>
+              <InlineField>
+                <Button variant="secondary" type="button" onClick={onResetWebhookVerifyToken}>
+                  Reset
+                </Button>
+              </InlineField>
+            </>
+          ) : (
+            <InlineField
+              label="Webhook token"
+              labelWidth={16}
+              tooltip="Verify token of the Strava push subscription. Subscription callback is the webhook resource of the data source exposed with a reverse proxy (see README), it keeps cache up to date when activities are created, changed or deleted."
+            >
+              <Input
+                width={50}
+                value={optionsWithDefaults.secureJsonData?.webhookVerifyToken || ''}
+                onChange={(event: ChangeEvent<HTMLInputElement>) => onWebhookVerifyTokenChange(event.target.value)}
+              />
+            </InlineField>
+          )}
+        </InlineFieldRow>
       </div>
       <div className="gf-form-group">
         <InlineFieldRow>
@@ -178,6 +352,125 @@ export const ConfigEditor = (props: Props) => {
             />
           </InlineField>
         </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Disk cache size"
+            labelWidth={16}
+            tooltip="Size of the persistent cache in MB. Cached data is stored in the plugin data directory and survives plugin restarts. Set to -1 to disable."
+          >
+            <Input
+              width={10}
+              type="number"
+              value={optionsWithDefaults.jsonData.diskCacheSizeMB ?? ''}
+              placeholder="100"
+              onChange={(event: ChangeEvent<HTMLInputElement>) => onDiskCacheSizeChange(event.target.value)}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Cache policies"
+            labelWidth={16}
+            tooltip='List of policies applied to the matching endpoints before default ones, for example: [{"pattern": "^athlete/activities$", "ttl": "15m", "persistent": false, "staleWhileRevalidate": "1h"}]. Empty TTL means data source cache TTL.'
+            invalid={!!cachePoliciesError}
+            error={cachePoliciesError}
+          >
+            <TextArea
+              cols={50}
+              rows={4}
+              value={cachePoliciesText}
+              placeholder='[{"pattern": "^athlete/activities$", "ttl": "15m"}]'
+              onChange={(event: ChangeEvent<HTMLTextAreaElement>) => setCachePoliciesText(event.target.value)}
+              onBlur={onCachePoliciesBlur}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Sync depth"
+            labelWidth={16}
+            tooltip="Number of the latest activities kept in cache along with their streams. Plugin fetches new activities periodically and loads older ones gradually within API rate limits. Set to -1 to disable background sync."
+          >
+            <Input
+              width={10}
+              type="number"
+              value={optionsWithDefaults.jsonData.syncDepth ?? ''}
+              placeholder="50"
+              onChange={(event: ChangeEvent<HTMLInputElement>) => onSyncDepthChange(event.target.value)}
+            />
+          </InlineField>
+          <InlineField label="Interval" tooltip="How often new activities are fetched.">
+            <Input
+              width={10}
+              value={optionsWithDefaults.jsonData.syncInterval || ''}
+              placeholder="15m"
+              onChange={(event: ChangeEvent<HTMLInputElement>) => onSyncIntervalChange(event.target.value)}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Sync streams"
+            labelWidth={16}
+            tooltip="Comma separated list of activity streams loaded with each activity."
+          >
+            <Input
+              width={40}
+              defaultValue={optionsWithDefaults.jsonData.syncStreams?.join(', ') || ''}
+              placeholder="velocity_smooth, heartrate, latlng"
+              onBlur={(event: ChangeEvent<HTMLInputElement>) => onSyncStreamsChange(event.target.value)}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Activity store"
+            labelWidth={16}
+            tooltip="Keep all athlete activities in the local database in the plugin data directory. Activities are synced in background and queries for long time ranges are answered without calling Strava API. Requires background sync."
+          >
+            <InlineSwitch
+              id="strava-activity-store"
+              value={optionsWithDefaults.jsonData.activityStore || false}
+              onChange={(event) =>
+                onOptionsChange({
+                  ...optionsWithDefaults,
+                  jsonData: {
+                    ...optionsWithDefaults.jsonData,
+                    activityStore: event!.currentTarget.checked,
+                  },
+                })
+              }
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Units"
+            labelWidth={16}
+            tooltip="Measurement units used to display distance, speed and pace. By default, athlete's preference is used."
+          >
+            <Select
+              width={20}
+              options={measurementPreferenceOptions}
+              value={optionsWithDefaults.jsonData.measurementPreference || ''}
+              onChange={(option) => onMeasurementPreferenceChange(option.value || undefined)}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Token storage"
+            labelWidth={16}
+            tooltip="Where refresh token is stored. By default, token is saved in the data source settings if plugin has a service account, otherwise in the encrypted file in the plugin data directory. Token stored in memory is lost after restart."
+          >
+            <Select
+              width={20}
+              options={tokenStoreOptions}
+              value={optionsWithDefaults.jsonData.tokenStore || ''}
+              onChange={(option) => onTokenStoreChange(option.value || undefined)}
+            />
+          </InlineField>
+        </InlineFieldRow>
       </div>
       {showConnectWithStravaButton && (
         <div className="gf-form-group">
@@ -185,9 +478,15 @@ export const ConfigEditor = (props: Props) => {
             <a type="button" href={connectWithStravaHref}>
               <img src="public/plugins/grafana-strava-datasource/img/btn_strava_connectwith_orange.svg" />
             </a>
+            <a type="button" href={addAthleteHref}>
+              <Button variant="secondary" type="button" icon="user">
+                Add athlete
+              </Button>
+            </a>
             {isLocationContainsCode() && (
               <Alert severity="success" title={''}>
                 Auth code successfully obtained. Save data source to finish authentication.
+                {isLocationContainsAddAthlete() && ' Athlete will be added to the data source.'}
               </Alert>
             )}
             {isLocationContainsError() && (
@@ -16,6 +16,7 @@ import {
   StravaAthlete,
   TopAchievementStat,
   StravaActivity,
+  StravaMeasurementPreference,
 } from '../types';
 import StravaDatasource from '../datasource';
 import { AthleteLabel } from './AthleteLabel';
@@ -94,6 +95,12 @@ const stravaActivitySplitOptions: Array<SelectableValue<StravaSplitStat>> = [
   { value: StravaSplitStat.MovingTime, label: 'Moving Time' },
 ];
 
+const measurementPreferenceOptions: Array<SelectableValue<StravaMeasurementPreference | ''>> = [
+  { value: '', label: 'Default', description: 'Data source or athlete preference' },
+  { value: StravaMeasurementPreference.Meters, label: 'Metric' },
+  { value: StravaMeasurementPreference.Feet, label: 'Imperial' },
+];
+
 const FORMAT_OPTIONS: Array<SelectableValue<StravaQueryFormat>> = [
   { label: 'Time series', value: StravaQueryFormat.TimeSeries },
   { label: 'Table', value: StravaQueryFormat.Table },
@@ -181,32 +188,62 @@ export interface Props extends QueryEditorProps<StravaDatasource, StravaQuery, S
 
 export const QueryEditor = ({ query, datasource, onChange, onRunQuery }: Props) => {
   query = { ...defaultQuery, ...query };
-  const [athlete, setAthlete] = useState<StravaAthlete | undefined>(datasource.athlete);
+  const [athlete, setAthlete] = useState<StravaAthlete | undefined>(query.athleteId ? undefined : datasource.athlete);
   const [{ loading: athleteLoading }, fetchAuthenticatedAthlete] = useAsyncFn(async () => {
-    const result = await datasource.stravaApi.getAuthenticatedAthlete();
+    const result = await datasource.stravaApi.getAuthenticatedAthlete(query.athleteId);
     setAthlete(result);
     return result;
+  }, [query.athleteId]);
+  const [{ value: athletesOptions }, fetchAthletesOptions] = useAsyncFn(async () => {
+    return await getAthletesOptions();
   });
   const [{ value: activitiesOptions }, fetchActivitiesOptions] = useAsyncFn(async () => {
     return await getActivitiesOptions(query.activityType);
-  }, [query.activityType]);
+  }, [query.activityType, query.athleteId]);
   const [{ value: segmentsOptions }, fetchSegmentsOptions] = useAsyncFn(async () => {
     const activityId = getTemplateSrv().replace(query.activityId?.toString());
     return await getSegmentsOptions(activityId);
-  }, [query.activityType]);
+  }, [query.activityType, query.athleteId]);
+
+  useEffect(() => {
+    fetchAthletesOptions();
+  }, [fetchAthletesOptions]);
 
   useEffect(() => {
-    if (!datasource.athlete) {
+    if (query.athleteId || !datasource.athlete) {
       fetchAuthenticatedAthlete();
+    } else {
+      setAthlete(datasource.athlete);
     }
     fetchActivitiesOptions();
     if (query.queryType === StravaQueryType.SegmentEffort) {
       fetchSegmentsOptions();
     }
-  }, [datasource.athlete, query.queryType, fetchAuthenticatedAthlete, fetchActivitiesOptions, fetchSegmentsOptions]);
+  }, [
+    datasource.athlete,
+    query.athleteId,
+    query.queryType,
+    fetchAuthenticatedAthlete,
+    fetchActivitiesOptions,
+    fetchSegmentsOptions,
+  ]);
+
+  const getAthletesOptions = async (): Promise<Array<SelectableValue<number>>> => {
+    try {
+      const athletes = await datasource.stravaApi.getAthletes();
+      return athletes.map((a) => ({
+        value: a.id,
+        label: `${a.firstname} ${a.lastname}`,
+        description: a.id ? 'Additional athlete' : 'Default athlete',
+      }));
+    } catch (error) {
+      console.log(error);
+      return [];
+    }
+  };
 
   const getActivitiesOptions = async (activityType: StravaActivityType): Promise<Array<SelectableValue<number>>> => {
-    let activities = await datasource.stravaApi.getActivities({ limit: 100 });
+    let activities = await datasource.stravaApi.getActivities({ limit: 100 }, query.athleteId);
     activities = datasource.filterActivities(activities, activityType);
     let options: Array<SelectableValue<number>> = activities.map((a) => ({
       value: a.id,
@@ -230,6 +267,7 @@ export const QueryEditor = ({ query, datasource, onChange, onRunQuery }: Props)
       let activity: StravaActivity = await datasource.stravaApi.getActivity({
         id: activityId,
         include_all_efforts: true,
+        athleteId: query.athleteId,
       });
       options = activity.segment_efforts?.map((a) => ({
         value: a.id,
@@ -250,6 +288,10 @@ export const QueryEditor = ({ query, datasource, onChange, onRunQuery }: Props)
     return variables.concat(options);
   };
 
+  const getSelectedAthlete = () => {
+    return athletesOptions?.find((v) => v.value === (query.athleteId || 0));
+  };
+
   const getSelectedQueryType = () => {
     return stravaQueryTypeOptions.find((v) => v.value === query.queryType);
   };
@@ -291,6 +333,10 @@ export const QueryEditor = ({ query, datasource, onChange, onRunQuery }: Props)
     return stravaActivityGraphOptions.find((v) => v.value === query.segmentGraph);
   };
 
+  const getMeasurementPreferenceOption = () => {
+    return measurementPreferenceOptions.find((v) => v.value === (query.measurementPreference || ''));
+  };
+
   const getFormatOption = () => {
     return FORMAT_OPTIONS.find((v) => v.value === query.format);
   };
@@ -322,6 +368,22 @@ export const QueryEditor = ({ query, datasource, onChange, onRunQuery }: Props)
     }
   };
 
+  const onMeasurementPreferenceChanged = (option: SelectableValue<StravaMeasurementPreference | ''>) => {
+    onChangeInternal({ ...query, measurementPreference: option.value || undefined });
+  };
+
+  const onAthleteChanged = (option: SelectableValue<number>) => {
+    // Selected activity and segment effort belong to the previous athlete
+    onChangeInternal({
+      ...query,
+      athleteId: option.value || undefined,
+      activityId: undefined,
+      selectedActivity: undefined,
+      segmentEffortId: undefined,
+      selectedSegmentEffort: undefined,
+    });
+  };
+
   const onFitToRangeChanged = (event: React.FormEvent<HTMLInputElement>) => {
     onChangeInternal({ ...query, fitToTimeRange: !query.fitToTimeRange });
   };
@@ -569,6 +631,17 @@ export const QueryEditor = ({ query, datasource, onChange, onRunQuery }: Props)
     <>
       <InlineFieldRow>
         <AthleteLabel athlete={athlete} isLoading={athleteLoading} />
+        {athletesOptions && athletesOptions.length > 1 && (
+          <InlineField label="Athlete" labelWidth={10}>
+            <Select
+              isSearchable={false}
+              width={24}
+              value={getSelectedAthlete()}
+              options={athletesOptions}
+              onChange={onAthleteChanged}
+            />
+          </InlineField>
+        )}
         <InlineField label="Query" labelWidth={10}>
           <Select
             isSearchable={false}
@@ -587,6 +660,15 @@ export const QueryEditor = ({ query, datasource, onChange, onRunQuery }: Props)
             onChange={onActivityTypeChanged}
           />
         </InlineField>
+        <InlineField label="Units" labelWidth={8}>
+          <Select
+            isSearchable={false}
+            width={14}
+            value={getMeasurementPreferenceOption()}
+            options={measurementPreferenceOptions}
+            onChange={onMeasurementPreferenceChanged}
+          />
+        </InlineField>
         <div className="gf-form gf-form--grow">
           <div className="gf-form-label gf-form-label--grow" />
         </div>
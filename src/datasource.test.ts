@@ -1,6 +1,6 @@
 import { dateMath } from '@grafana/data';
 import StravaDatasource from './datasource';
-import { StravaQueryType } from './types';
+import { StravaMeasurementPreference, StravaQuery, StravaQueryType } from './types';
 
 jest.mock(
   '@grafana/runtime',
@@ -83,10 +83,39 @@ describe('StravaDatasource', () => {
     it('should round time range to hit the cache', async () => {
       const spy = jest.spyOn(ctx.ds.stravaApi, 'getActivities');
       await ctx.ds.query(ctx.options);
-      expect(spy).toHaveBeenLastCalledWith({
-        after: 1672531200,
-        before: 1685577600,
-      });
+      expect(spy).toHaveBeenLastCalledWith(
+        {
+          after: 1672531200,
+          before: 1685577600,
+        },
+        undefined
+      );
+    });
+
+    it('should fetch activities of each selected athlete', async () => {
+      const spy = jest.spyOn(ctx.ds.stravaApi, 'getActivities');
+      ctx.options.targets = [
+        { refId: 'A', queryType: StravaQueryType.Activities },
+        { refId: 'B', queryType: StravaQueryType.Activities, athleteId: 42 },
+        { refId: 'C', queryType: StravaQueryType.Activities, athleteId: 42 },
+      ];
+      await ctx.ds.query(ctx.options);
+      expect(spy).toHaveBeenCalledTimes(2);
+      expect(spy).toHaveBeenCalledWith(expect.anything(), undefined);
+      expect(spy).toHaveBeenCalledWith(expect.anything(), 42);
+    });
+  });
+
+  describe('When measurement preference is set in the query', () => {
+    it('should override data source preference', () => {
+      ctx.ds.measurementPreference = StravaMeasurementPreference.Meters;
+      const target = { measurementPreference: StravaMeasurementPreference.Feet } as StravaQuery;
+      expect(ctx.ds.getMeasurementPreference(target)).toBe(StravaMeasurementPreference.Feet);
+    });
+
+    it('should use data source preference if not set', () => {
+      ctx.ds.measurementPreference = StravaMeasurementPreference.Feet;
+      expect(ctx.ds.getMeasurementPreference({} as StravaQuery)).toBe(StravaMeasurementPreference.Feet);
     });
   });
 });
@@ -77,6 +77,7 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
   athlete?: StravaAthlete;
   measurementPreference: StravaMeasurementPreference;
   oauthPassThru: boolean;
+  measurementPreferenceOverride?: StravaMeasurementPreference;
 
   constructor(instanceSettings: DataSourceInstanceSettings<StravaJsonData>) {
     super(instanceSettings);
@@ -87,26 +88,34 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
     this.activities = [];
     this.measurementPreference = StravaMeasurementPreference.Meters;
     this.oauthPassThru = instanceSettings.jsonData.oauthPassThru;
+    this.measurementPreferenceOverride = instanceSettings.jsonData.measurementPreference;
   }
 
   async query(options: DataQueryRequest<StravaQuery>) {
     const data: any[] = [];
-    let activities: StravaActivity[] = [];
+    // Activities are fetched once for each athlete selected in the queries
+    const athleteActivities = new Map<number | undefined, StravaActivity[]>();
 
     if (!this.athlete) {
       this.athlete = await this.stravaApi.getAuthenticatedAthlete();
-      this.measurementPreference = this.athlete?.measurement_preference || StravaMeasurementPreference.Meters;
+      this.measurementPreference =
+        this.measurementPreferenceOverride ||
+        this.athlete?.measurement_preference ||
+        StravaMeasurementPreference.Meters;
     }
 
-    let queryActivities = options.targets.some((t) => t.queryType === StravaQueryType.Activities);
+    const activitiesTargets = options.targets.filter((t) => t.queryType === StravaQueryType.Activities);
 
-    if (queryActivities) {
+    if (activitiesTargets.length > 0) {
       let before = options.range?.to.unix();
       let after = options.range?.from.unix();
       // Round time to cache interval in order to hit cache
       before = Math.floor(before / DEFAULT_ACTIVITIES_CACHE_INTERVAL) * DEFAULT_ACTIVITIES_CACHE_INTERVAL;
       after = Math.floor(after / DEFAULT_ACTIVITIES_CACHE_INTERVAL) * DEFAULT_ACTIVITIES_CACHE_INTERVAL;
-      activities = await this.stravaApi.getActivities({ before, after });
+      const athleteIds = activitiesTargets.map((t) => t.athleteId || undefined);
+      for (const athleteId of athleteIds.filter((id, i) => athleteIds.indexOf(id) === i)) {
+        athleteActivities.set(athleteId, await this.stravaApi.getActivities({ before, after }, athleteId));
+      }
     }
 
     for (const target of options.targets) {
@@ -115,8 +124,9 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
       }
 
       if (target.queryType === StravaQueryType.Activities) {
+        const activities = athleteActivities.get(target.athleteId || undefined) || [];
         const filteredActivities = this.filterActivities(activities, target.activityType);
-        const transformOptions = { measurementPreference: this.measurementPreference };
+        const transformOptions = { measurementPreference: this.getMeasurementPreference(target) };
         switch (target.format) {
           case StravaQueryFormat.Table:
             const tableData = transformActivitiesToTable(filteredActivities, target, transformOptions);
@@ -152,11 +162,20 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
     return { data };
   }
 
+  /**
+   * Returns measurement preference set in the query, or data source/athlete preference if not overridden.
+   */
+  getMeasurementPreference(target: StravaQuery): StravaMeasurementPreference {
+    return target.measurementPreference || this.measurementPreference;
+  }
+
   async queryActivity(options: DataQueryRequest<StravaQuery>, target: StravaQuery) {
+    const mp = this.getMeasurementPreference(target);
     const activityId = getTemplateSrv().replace(target.activityId?.toString());
     const activity = await this.stravaApi.getActivity({
       id: activityId,
       include_all_efforts: true,
+      athleteId: target.athleteId,
     });
 
     if (target.activityData === StravaActivityData.Stats) {
@@ -187,6 +206,7 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
     const streams = await this.stravaApi.getActivityStreams({
       id: activityId,
       streamTypes: [activityStream],
+      athleteId: target.athleteId,
     });
 
     const timeFiled: MutableField<number> = {
@@ -231,23 +251,23 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
       if (activity.sport_type === 'Run') {
         valueFiled.name = 'pace';
         valueFiled.config.unit = 'dthms';
-        streamValues = velocityDataToPace(streamValues, this.measurementPreference);
+        streamValues = velocityDataToPace(streamValues, mp);
       } else {
         valueFiled.name = 'speed';
-        valueFiled.config.unit = getPreferredSpeedUnit(this.measurementPreference);
-        streamValues = velocityDataToSpeed(streamValues, this.measurementPreference);
+        valueFiled.config.unit = getPreferredSpeedUnit(mp);
+        streamValues = velocityDataToSpeed(streamValues, mp);
       }
     }
 
     if (target.activityGraph === StravaActivityStream.Velocity) {
       valueFiled.name = 'speed';
-      valueFiled.config.unit = getPreferredSpeedUnit(this.measurementPreference);
-      streamValues = velocityDataToSpeed(streamValues, this.measurementPreference);
+      valueFiled.config.unit = getPreferredSpeedUnit(mp);
+      streamValues = velocityDataToSpeed(streamValues, mp);
     }
 
     if (target.activityGraph === StravaActivityStream.Altitude) {
-      valueFiled.config.unit = getPreferredLengthUnit(this.measurementPreference);
-      streamValues = metersDataToFeet(streamValues, this.measurementPreference);
+      valueFiled.config.unit = getPreferredLengthUnit(mp);
+      streamValues = metersDataToFeet(streamValues, mp);
     }
 
     // Smooth data
@@ -269,11 +289,13 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
   }
 
   async queryActivitySegment(options: DataQueryRequest<StravaQuery>, target: StravaQuery) {
+    const mp = this.getMeasurementPreference(target);
     const activityId = getTemplateSrv().replace(target.activityId?.toString());
     const segmentEffortId = getTemplateSrv().replace(target.segmentEffortId?.toString());
     const activity: StravaActivity = await this.stravaApi.getActivity({
       id: activityId,
       include_all_efforts: true,
+      athleteId: target.athleteId,
     });
 
     const segmentEffort = activity.segment_efforts?.find((se) => se.id.toString() === segmentEffortId);
@@ -297,6 +319,7 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
     const streams = await this.stravaApi.getActivityStreams({
       id: activityId,
       streamTypes: [segmentStream],
+      athleteId: target.athleteId,
     });
 
     const timeFiled: MutableField<number> = {
@@ -342,23 +365,23 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
       if (activity.sport_type === 'Run') {
         valueFiled.name = 'pace';
         valueFiled.config.unit = 'dthms';
-        streamValues = velocityDataToPace(streamValues, this.measurementPreference);
+        streamValues = velocityDataToPace(streamValues, mp);
       } else {
         valueFiled.name = 'speed';
-        valueFiled.config.unit = getPreferredSpeedUnit(this.measurementPreference);
-        streamValues = velocityDataToSpeed(streamValues, this.measurementPreference);
+        valueFiled.config.unit = getPreferredSpeedUnit(mp);
+        streamValues = velocityDataToSpeed(streamValues, mp);
       }
     }
 
     if (target.activityGraph === StravaActivityStream.Velocity) {
       valueFiled.name = 'speed';
-      valueFiled.config.unit = getPreferredSpeedUnit(this.measurementPreference);
-      streamValues = velocityDataToSpeed(streamValues, this.measurementPreference);
+      valueFiled.config.unit = getPreferredSpeedUnit(mp);
+      streamValues = velocityDataToSpeed(streamValues, mp);
     }
 
     if (target.activityGraph === StravaActivityStream.Altitude) {
-      valueFiled.config.unit = getPreferredLengthUnit(this.measurementPreference);
-      streamValues = metersDataToFeet(streamValues, this.measurementPreference);
+      valueFiled.config.unit = getPreferredLengthUnit(mp);
+      streamValues = metersDataToFeet(streamValues, mp);
     }
 
     // Smooth data
@@ -380,6 +403,7 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
   }
 
   queryActivitySplits(activity: StravaActivity, target: StravaQuery, options: DataQueryRequest<StravaQuery>) {
+    const mp = this.getMeasurementPreference(target);
     const timeFiled: MutableField<number> = {
       name: TIME_SERIES_TIME_FIELD_NAME,
       type: FieldType.time,
@@ -409,7 +433,7 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
       ts = options.range.from.unix();
     }
 
-    const isMetric = this.measurementPreference === StravaMeasurementPreference.Meters;
+    const isMetric = mp === StravaMeasurementPreference.Meters;
     const splits: any[] = isMetric ? activity.splits_metric : activity.splits_standard;
     for (let i = 0; i < splits.length; i++) {
       const split = splits[i];
@@ -420,10 +444,10 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
       } else if (splitStat === StravaSplitStat.Pace) {
         if (activity.sport_type === 'Run') {
           valueFiled.config.unit = 'dthms';
-          value = getPreferredPace(split[StravaSplitStat.Speed], this.measurementPreference);
+          value = getPreferredPace(split[StravaSplitStat.Speed], mp);
         } else {
-          valueFiled.config.unit = getPreferredSpeedUnit(this.measurementPreference);
-          value = getPreferredSpeed(split[StravaSplitStat.Speed], this.measurementPreference);
+          valueFiled.config.unit = getPreferredSpeedUnit(mp);
+          value = getPreferredSpeed(split[StravaSplitStat.Speed], mp);
         }
       }
       valueFiled.values.add(value);
@@ -437,6 +461,7 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
   }
 
   queryActivityStats(activity: StravaActivity, target: StravaQuery, options: DataQueryRequest<StravaQuery>) {
+    const mp = this.getMeasurementPreference(target);
     const stats = target.singleActivityStat || 'name';
     const valueFiled: MutableField<number | null> = {
       name: stats,
@@ -452,10 +477,10 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
     if (stats === 'pace') {
       if (activity.sport_type === 'Run') {
         valueFiled.config.unit = 'dthms';
-        activityStats = getPreferredPace(activity.average_speed, this.measurementPreference);
+        activityStats = getPreferredPace(activity.average_speed, mp);
       } else {
-        valueFiled.config.unit = getPreferredSpeedUnit(this.measurementPreference);
-        activityStats = getPreferredSpeed(activity.average_speed, this.measurementPreference);
+        valueFiled.config.unit = getPreferredSpeedUnit(mp);
+        activityStats = getPreferredSpeed(activity.average_speed, mp);
       }
     }
     if (stats === TopAchievementStat) {
@@ -472,12 +497,12 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
       activityStats = topAchievement;
     }
     if (stats === StravaActivityStat.Distance) {
-      valueFiled.config.unit = this.measurementPreference === StravaMeasurementPreference.Feet ? 'lengthmi' : 'lengthm';
-      activityStats = getPreferredDistance(activity.distance, this.measurementPreference);
+      valueFiled.config.unit = mp === StravaMeasurementPreference.Feet ? 'lengthmi' : 'lengthm';
+      activityStats = getPreferredDistance(activity.distance, mp);
     }
     if (stats === StravaActivityStat.ElevationGain) {
-      valueFiled.config.unit = this.measurementPreference === StravaMeasurementPreference.Feet ? 'lengthft' : 'lengthm';
-      activityStats = getPreferredLength(activity.total_elevation_gain, this.measurementPreference);
+      valueFiled.config.unit = mp === StravaMeasurementPreference.Feet ? 'lengthft' : 'lengthm';
+      activityStats = getPreferredLength(activity.total_elevation_gain, mp);
     }
 
     const frame = new MutableDataFrame({
@@ -495,8 +520,9 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
   }
 
   async queryActivitySegments(activity: StravaActivity, target: StravaQuery, options: DataQueryRequest<StravaQuery>) {
-    const distanceUnit = this.measurementPreference === StravaMeasurementPreference.Feet ? 'lengthmi' : 'lengthm';
-    const lengthUnit = this.measurementPreference === StravaMeasurementPreference.Feet ? 'lengthft' : 'lengthm';
+    const mp = this.getMeasurementPreference(target);
+    const distanceUnit = mp === StravaMeasurementPreference.Feet ? 'lengthmi' : 'lengthm';
+    const lengthUnit = mp === StravaMeasurementPreference.Feet ? 'lengthft' : 'lengthm';
 
     const frame = new MutableDataFrame({
       refId: target.refId,
@@ -521,7 +547,9 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
 
     const segments = activity.segment_efforts;
     if (segments?.length > 0) {
-      let detailedSegments = await Promise.all(segments.map((s) => this.stravaApi.getSegment(s.segment.id)));
+      let detailedSegments = await Promise.all(
+        segments.map((s) => this.stravaApi.getSegment(s.segment.id, target.athleteId))
+      );
 
       for (let i = 0; i < segments.length; i++) {
         const effort = segments[i];
@@ -531,10 +559,10 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
         let pace: number;
         if (effort.segment.activity_type === 'Run') {
           frame.fields[paceFieldIdx].config.unit = 'dthms';
-          pace = getPreferredPace(effort.distance / effort.moving_time, this.measurementPreference);
+          pace = getPreferredPace(effort.distance / effort.moving_time, mp);
         } else {
-          frame.fields[paceFieldIdx].config.unit = getPreferredSpeedUnit(this.measurementPreference);
-          pace = getPreferredSpeed(effort.distance / effort.moving_time, this.measurementPreference);
+          frame.fields[paceFieldIdx].config.unit = getPreferredSpeedUnit(mp);
+          pace = getPreferredSpeed(effort.distance / effort.moving_time, mp);
         }
 
         const dataRow: any = {
@@ -544,11 +572,8 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
           pace: pace,
           'heart rate': effort.average_heartrate,
           power: effort.average_watts,
-          distance: getPreferredDistance(effort.distance, this.measurementPreference),
-          'elevation gain': getPreferredLength(
-            effort.segment.elevation_high - effort.segment.elevation_low,
-            this.measurementPreference
-          ),
+          distance: getPreferredDistance(effort.distance, mp),
+          'elevation gain': getPreferredLength(effort.segment.elevation_high - effort.segment.elevation_low, mp),
           grade: effort.segment.average_grade,
           PR: segment?.athlete_segment_stats?.pr_elapsed_time,
           KOM: segment?.xoms?.overall,
@@ -587,6 +612,7 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
     try {
       const streams = await this.stravaApi.getActivityStreams({
         id: activity.id,
+        athleteId: target.athleteId,
         streamTypes: [
           StravaActivityStream.LatLng,
           StravaActivityStream.Velocity,
@@ -654,6 +680,7 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
     try {
       const streams = await this.stravaApi.getActivityStreams({
         id: activity.id,
+        athleteId: target.athleteId,
         streamTypes: [
           StravaActivityStream.LatLng,
           StravaActivityStream.Velocity,
@@ -716,9 +743,10 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
       await this.stravaApi.resetCache();
       const authCode = this.getAuthCodeFromLocation();
       if (authCode) {
-        // Exchange auth code for new refresh token if "Connect with Strava" button clicked
+        // Exchange auth code for new refresh token if "Connect with Strava" or "Add athlete" button clicked
+        const addAthlete = /state=add_athlete/.test(window.location.search);
         try {
-          await this.stravaApi.exchangeToken(authCode);
+          await this.stravaApi.exchangeToken(authCode, addAthlete);
         } catch (err) {
           console.log(err);
         }
@@ -726,14 +754,8 @@ export default class StravaDatasource extends DataSourceApi<StravaQuery, StravaJ
     }
 
     try {
-      const athlete = await this.stravaApi.getAuthenticatedAthlete();
-      if (!athlete) {
-        return { status: 'error', message: `Cannot get authenticated user.` };
-      }
-      return {
-        status: 'success',
-        message: `Data source is working. Authenticated as ${athlete.firstname} ${athlete.lastname}.`,
-      };
+      const health = await this.stravaApi.checkHealth(this.uid);
+      return { status: 'success', message: health?.message };
     } catch (err: any) {
       const message = err?.data?.message || '';
       return { status: 'error', message: `Cannot connect to Strava API${message ? ': ' + message : ''}` };
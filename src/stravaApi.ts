@@ -1,5 +1,5 @@
 import { getBackendSrv } from '@grafana/runtime';
-import { DataStreamSet, Segment, StravaActivity, StravaAthlete, StreamType } from 'types';
+import { DataStreamSet, Segment, StravaActivity, StravaAthlete, StravaAuthorizedAthlete, StreamType } from 'types';
 
 export default class StravaApi {
   datasourceId: number;
@@ -18,33 +18,44 @@ export default class StravaApi {
     this.apiUrl = '';
   }
 
-  async getAuthenticatedAthlete(): Promise<StravaAthlete> {
-    return await this.tsdbRequest('athlete');
+  /**
+   * Athlete id selects one of the athletes authorized in the data source, default athlete is used if not set.
+   */
+  async getAuthenticatedAthlete(athleteId?: number): Promise<StravaAthlete> {
+    return await this.tsdbRequest('athlete', undefined, athleteId);
   }
 
-  async getActivities(params?: any): Promise<StravaActivity[]> {
-    return await this.requestWithPagination('athlete/activities', params);
+  async getActivities(params?: any, athleteId?: number): Promise<StravaActivity[]> {
+    return await this.requestWithPagination('athlete/activities', params, athleteId);
   }
 
   async getActivity(params?: any): Promise<StravaActivity> {
-    const { id, include_all_efforts } = params;
-    return await this.tsdbRequest(`/activities/${id}`, { include_all_efforts });
+    const { id, include_all_efforts, athleteId } = params;
+    return await this.tsdbRequest(`/activities/${id}`, { include_all_efforts }, athleteId);
   }
 
-  async getActivityStreams(params: { id: number | string; streamTypes: StreamType[] }): Promise<DataStreamSet<any>> {
-    const { id, streamTypes } = params;
+  async getActivityStreams(params: {
+    id: number | string;
+    streamTypes: StreamType[];
+    athleteId?: number;
+  }): Promise<DataStreamSet<any>> {
+    const { id, streamTypes, athleteId } = params;
     const streams = streamTypes.join(',');
-    return await this.tsdbRequest(`/activities/${id}/streams`, {
-      key_by_type: true,
-      keys: `${streams},time`,
-    });
+    return await this.tsdbRequest(
+      `/activities/${id}/streams`,
+      {
+        key_by_type: true,
+        keys: `${streams},time`,
+      },
+      athleteId
+    );
   }
 
-  async getSegment(id: number): Promise<Segment> {
-    return await this.tsdbRequest(`/segments/${id}`);
+  async getSegment(id: number, athleteId?: number): Promise<Segment> {
+    return await this.tsdbRequest(`/segments/${id}`, undefined, athleteId);
   }
 
-  async requestWithPagination(url: string, params?: any) {
+  async requestWithPagination(url: string, params?: any, athleteId?: number) {
     let data: any[] = [];
     let chunk = [];
     let page = 1;
@@ -62,7 +73,7 @@ export default class StravaApi {
       };
       try {
         // chunk = await this.request(url, params);
-        chunk = await this.tsdbRequest(url, params);
+        chunk = await this.tsdbRequest(url, params, athleteId);
       } catch (error) {
         throw error;
       }
@@ -72,8 +83,13 @@ export default class StravaApi {
     return data;
   }
 
-  async exchangeToken(authCode: any) {
-    return await this.tsdbAuthRequest({ authCode });
+  async exchangeToken(authCode: any, addAthlete?: boolean) {
+    return await this.tsdbAuthRequest({ authCode, addAthlete });
+  }
+
+  async getAthletes(): Promise<StravaAuthorizedAthlete[]> {
+    const response = await getBackendSrv().get(`/api/datasources/${this.datasourceId}/resources/athletes`);
+    return this.handleTsdbResponse(response);
   }
 
   async resetAccessToken() {
@@ -86,6 +102,10 @@ export default class StravaApi {
     }
   }
 
+  async checkHealth(uid: string) {
+    return await getBackendSrv().get(`/api/datasources/uid/${uid}/health`);
+  }
+
   async resetCache() {
     try {
       const response = await getBackendSrv().get(`/api/datasources/${this.datasourceId}/resources/reset-cache`);
@@ -114,11 +134,11 @@ export default class StravaApi {
     }
   }
 
-  async tsdbRequest(endpoint: string, params?: any) {
-    return this.proxyfy(this._tsdbRequest, '_tsdbRequest', this)(endpoint, params);
+  async tsdbRequest(endpoint: string, params?: any, athleteId?: number) {
+    return this.proxyfy(this._tsdbRequest, '_tsdbRequest', this)(endpoint, params, athleteId);
   }
 
-  async _tsdbRequest(endpoint: string, params?: any) {
+  async _tsdbRequest(endpoint: string, params?: any, athleteId?: number) {
     try {
       const response = await getBackendSrv().datasourceRequest({
         url: this.backendAPIUrl,
@@ -130,6 +150,7 @@ export default class StravaApi {
           datasourceId: this.datasourceId,
           endpoint,
           params,
+          athleteId,
         },
       });
       return this.handleTsdbResponse(response);
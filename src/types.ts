@@ -4,6 +4,30 @@ export interface StravaJsonData extends DataSourceJsonData {
   clientID: string;
   cacheTTL: string;
   oauthPassThru: boolean;
+  measurementPreference?: StravaMeasurementPreference;
+  tokenStore?: StravaTokenStore;
+  diskCacheSizeMB?: number;
+  cacheMaxEntries?: number;
+  cacheMaxSizeMB?: number;
+  cachePolicies?: StravaCachePolicy[];
+  syncDepth?: number;
+  syncInterval?: string;
+  syncStreams?: string[];
+  activityStore?: boolean;
+}
+
+export interface StravaCachePolicy {
+  pattern: string;
+  ttl?: string;
+  persistent?: boolean;
+  staleWhileRevalidate?: string;
+}
+
+export interface StravaAuthorizedAthlete {
+  id: number;
+  athleteId: number;
+  firstname: string;
+  lastname: string;
 }
 
 export interface StravaAthlete {
@@ -281,12 +305,20 @@ export enum StravaMeasurementPreference {
   Feet = 'feet',
 }
 
+export enum StravaTokenStore {
+  Grafana = 'grafana',
+  File = 'file',
+  Memory = 'memory',
+}
+
 export interface StravaSecureJsonData {
   clientSecret: string;
+  webhookVerifyToken?: string;
 }
 
 export interface StravaQuery extends DataQuery {
   queryType: StravaQueryType;
+  athleteId?: number;
   activityStat: StravaActivityStat;
   activityType: StravaActivityType;
   format: StravaQueryFormat;
@@ -303,6 +335,7 @@ export interface StravaQuery extends DataQuery {
   selectedSegmentEffort?: SelectableValue<number>;
   segmentData?: string;
   segmentGraph?: StravaActivityStream;
+  measurementPreference?: StravaMeasurementPreference;
 }
 
 export enum StravaQueryFormat {